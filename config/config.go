@@ -1,17 +1,22 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Type definitions for various errors raised by config package.
-
 // Type definitions for various errors raised by config package.
 type (
 	DirectoryNotFoundError  string
@@ -19,6 +24,8 @@ type (
 	InvalidPathError        string
 	NotRegularFileError     string
 	FileExistsError         string
+	IncludeCycleError       string
+	ValidationError         []string
 )
 
 // Error returns the error message for DirectoryNotFoundError.
@@ -26,32 +33,135 @@ func (e DirectoryNotFoundError) Error() string {
 	return "directory not found: " + string(e)
 }
 
+// Type returns the type name of DirectoryNotFoundError.
+func (e DirectoryNotFoundError) Type() string {
+	return "DirectoryNotFoundError"
+}
+
 // Error returns the error message for ConfigFileNotFoundError.
 func (e ConfigFileNotFoundError) Error() string {
 	return "configuration file not found: " + string(e)
 }
 
+// Type returns the type name of ConfigFileNotFoundError.
+func (e ConfigFileNotFoundError) Type() string {
+	return "ConfigFileNotFoundError"
+}
+
 // Error returns the error message for InvalidPathError.
 func (e InvalidPathError) Error() string {
 	return "invalid file path: " + string(e)
 }
 
+// Type returns the type name of InvalidPathError.
+func (e InvalidPathError) Type() string {
+	return "InvalidPathError"
+}
+
 // Error returns the error message for NotRegularFileError.
 func (e NotRegularFileError) Error() string {
 	return "not a regular file: " + string(e)
 }
 
+// Type returns the type name of NotRegularFileError.
+func (e NotRegularFileError) Type() string {
+	return "NotRegularFileError"
+}
+
 // Error returns the error message for FileExistsError.
 func (e FileExistsError) Error() string {
 	return "file already exists: " + string(e)
 }
 
+// Type returns the type name of FileExistsError.
+func (e FileExistsError) Type() string {
+	return "FileExistsError"
+}
+
+// Error returns the error message for IncludeCycleError.
+func (e IncludeCycleError) Error() string {
+	return "configuration include cycle: " + string(e)
+}
+
+// Type returns the type name of IncludeCycleError.
+func (e IncludeCycleError) Type() string {
+	return "IncludeCycleError"
+}
+
+// Error returns the error message for ValidationError, listing all problems.
+func (e ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e, "; ")
+}
+
+// Type returns the type name of ValidationError.
+func (e ValidationError) Type() string {
+	return "ValidationError"
+}
+
+// Format represents the file format of a configuration file.
+type Format int
+
+// Constant values of enumerated type Format.
+const (
+	UnknownFormat Format = iota
+	YAML
+	JSON
+)
+
+// FormatOf returns the configuration file format indicated by the extension of
+// the given file path.
+func FormatOf(filePath string) Format {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yml", ".yaml":
+		return YAML
+	case ".json":
+		return JSON
+	}
+	return UnknownFormat
+}
+
 // Config represents a configuration file, containing the repositories to
 // export and how to package them.
+// The Include field lists other configuration files (relative to the directory
+// of the including file) whose content is merged, in order, beneath the content
+// of the including file. See Parse for details.
+// The CacheDir field, if non-empty, is the directory containing the working
+// copy of each export without a local path, in a subdirectory named for the
+// export. Files copied from the cache into a package are hard linked rather
+// than copied, if possible, so they must never be modified in place.
+// The Svn field configures the svn command run by every SVN export.
 type Config struct {
-	path    string
-	Export  ExportMap  `yaml:"export,omitempty"`
-	Package PackageMap `yaml:"package,omitempty"`
+	path     string
+	format   Format
+	source   []byte     // content parsed, updated in place by Write
+	parts    []*Config  // files merged, if parsed from a directory
+	Include  PathList   `yaml:"include,omitempty,flow" json:"include,omitempty"`
+	CacheDir string     `yaml:"cacheDir,omitempty" json:"cacheDir,omitempty"`
+	Svn      SvnConfig  `yaml:"svn,omitempty" json:"svn,omitempty"`
+	Export   ExportMap  `yaml:"export,omitempty" json:"export,omitempty"`
+	Package  PackageMap `yaml:"package,omitempty" json:"package,omitempty"`
+}
+
+// SvnConfig represents the svn command run by SVN exports.
+// The Path field is the svn executable, either a file path or a command name
+// searched for in PATH (default "svn").
+// The Args field lists additional options (e.g., "--config-dir", "dir") passed
+// to every svn command, after the subcommand and before the options derived
+// from each export. In particular, if an export has a Username or Password,
+// the options "--non-interactive", "--username", and "--password" follow Args,
+// so they must not be given in Args as well. Non-interactive authentication can
+// be required of every export by adding "--non-interactive" to Args.
+type SvnConfig struct {
+	Path string   `yaml:"path,omitempty" json:"path,omitempty"`
+	Args []string `yaml:"args,omitempty,flow" json:"args,omitempty"`
+}
+
+// Executable returns the svn executable, Path or else "svn".
+func (s SvnConfig) Executable() string {
+	if s.Path == "" {
+		return "svn"
+	}
+	return s.Path
 }
 
 // ExportMap represents named SVN repository paths to export.
@@ -60,11 +170,34 @@ type Config struct {
 type ExportMap map[string]ExportConfig
 
 // ExportConfig represents the configuration for a single repository.
+// The Type field selects the VCS implementation ("svn" or "git"); if empty,
+// the VCS is detected automatically from the remote URL.
+// The Username and Password fields are passed to svn for authentication, and
+// they may reference variables (e.g., "$SVN_PASS") to avoid storing secrets in
+// the configuration file.
+// The Revision field, if non-empty, pins the working copy to the given
+// revision; otherwise, the latest revision (HEAD) is exported.
+// The Mode field selects how the repository is retrieved: "checkout" (the
+// default) maintains a working copy that is updated incrementally, while
+// "export" (SVN only) retrieves a clean tree without VCS metadata each run.
+// If the IgnoreExternals field is true (SVN only), svn:externals definitions
+// are not retrieved; by default, externals are retrieved with the working copy.
+// The Mirror field, if non-empty, is a local directory that receives a copy of
+// the working copy (without VCS metadata) after each successful export.
 type ExportConfig struct {
-	Repo  string `yaml:"repo"`
-	Path  string `yaml:"path"`
-	Local string `yaml:"local"`
-	Last  string `yaml:"last,omitempty"`
+	Type            string   `yaml:"type,omitempty" json:"type,omitempty"`
+	Repo            string   `yaml:"repo" json:"repo"`
+	Path            string   `yaml:"path" json:"path"`
+	Local           string   `yaml:"local" json:"local"`
+	Username        string   `yaml:"username,omitempty" json:"username,omitempty"`
+	Password        string   `yaml:"password,omitempty" json:"password,omitempty"`
+	Revision        string   `yaml:"revision,omitempty" json:"revision,omitempty"`
+	Depth           string   `yaml:"depth,omitempty" json:"depth,omitempty"`
+	Paths           []string `yaml:"paths,omitempty,flow" json:"paths,omitempty"`
+	IgnoreExternals bool     `yaml:"ignoreExternals,omitempty" json:"ignoreExternals,omitempty"`
+	Mode            string   `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Mirror          string   `yaml:"mirror,omitempty" json:"mirror,omitempty"`
+	Last            string   `yaml:"last,omitempty" json:"last,omitempty"`
 }
 
 // urlProtocol is a regular expression that matches protocol string prefixes of
@@ -88,6 +221,12 @@ func (e *ExportConfig) Wc() string {
 	return filepath.Join(e.Local, e.Path)
 }
 
+// CleanExport returns true if and only if the repository is retrieved as a
+// clean tree without VCS metadata (i.e., Mode is "export").
+func (e *ExportConfig) CleanExport() bool {
+	return strings.EqualFold(e.Mode, "export")
+}
+
 // LastValid returns true if and only if Last is a valid SVN revision
 // identifier.
 func (e *ExportConfig) LastValid() bool {
@@ -95,14 +234,65 @@ func (e *ExportConfig) LastValid() bool {
 	return e.Last != ""
 }
 
+// svnRevision is a regular expression that matches the SVN revision forms
+// accepted by the -r option: a revision number, a keyword, or a date enclosed
+// in curly braces.
+var svnRevision = regexp.MustCompile(
+	`^(\d+|HEAD|BASE|COMMITTED|PREV|\{\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?)?(Z|[+-]\d{2}:?\d{2})?\})$`)
+
+// RevisionValid returns true if and only if Revision is empty or a valid
+// revision identifier for the configured VCS type.
+// Git revisions may be any non-empty reference (commit, branch, or tag)
+// without whitespace; all others must be a valid SVN revision identifier.
+func (e *ExportConfig) RevisionValid() bool {
+	if e.Revision == "" {
+		return true
+	}
+	if strings.EqualFold(e.Type, "git") {
+		return !strings.ContainsAny(e.Revision, " \t\r\n")
+	}
+	return svnRevision.MatchString(e.Revision)
+}
+
 // PackageMap represents all package operations to perform.
 type PackageMap map[string]PackageConfig
 
 // PackageConfig represents the configuration for a single package destination.
+// If the Roster field is true, a ROSTER.tsv file listing the path, size, and
+// source repository and revision of every file in the package is written into
+// the package root, before the package is archived.
+// If the Changelog field is true, a CHANGELOG file listing the commits made to
+// each included repository since its previous export is written into the
+// package.
+// The ExcludeVcsMeta field controls whether VCS metadata (e.g., .svn and .git)
+// is excluded from all copy operations, in addition to their ignore patterns.
+// It is enabled by default.
+// The PreHook and PostHook fields list shell commands that are run, in order,
+// before any content is copied into the package and after the package is
+// complete (including its archive), respectively.
+// If the Prune field is true, all content of each copy destination that was
+// not copied by the current run (e.g., files deleted from the repository) is
+// removed after all content is copied. Content of the package outside of the
+// copy destinations is never removed. Prune is not supported with the
+// ModifiedSince or deep Symlinks copy options.
 type PackageConfig struct {
-	Roster   bool           `yaml:"roster,omitempty"`
-	Include  IncludeList    `yaml:"include,omitempty"`
-	Compress CompressConfig `yaml:"compress,omitempty"`
+	Roster         bool           `yaml:"roster,omitempty" json:"roster,omitempty"`
+	Changelog      bool           `yaml:"changelog,omitempty" json:"changelog,omitempty"`
+	Prune          bool           `yaml:"prune,omitempty" json:"prune,omitempty"`
+	ExcludeVcsMeta *bool          `yaml:"excludeVcsMeta,omitempty" json:"excludeVcsMeta,omitempty"`
+	PreHook        CommandList    `yaml:"preHook,omitempty" json:"preHook,omitempty"`
+	PostHook       CommandList    `yaml:"postHook,omitempty" json:"postHook,omitempty"`
+	Include        IncludeList    `yaml:"include,omitempty" json:"include,omitempty"`
+	Compress       CompressConfig `yaml:"compress,omitempty" json:"compress,omitempty"`
+}
+
+// CommandList represents a list of commands that may be written in the
+// configuration as either a single string or a list of strings.
+type CommandList = PathList
+
+// ExcludesVcsMeta returns true unless ExcludeVcsMeta is explicitly false.
+func (p *PackageConfig) ExcludesVcsMeta() bool {
+	return nil == p.ExcludeVcsMeta || *p.ExcludeVcsMeta
 }
 
 // IncludeList represents the list of repositories to include in a package.
@@ -119,32 +309,208 @@ type IncludePathList []IncludePathOp
 // IncludePathOp represents the available operations and their respective
 // configurations which can be performed on a path included with a package.
 type IncludePathOp struct {
-	Copy IncludeCopyConfig `yaml:"copy,flow,omitempty"`
+	Copy IncludeCopyConfig `yaml:"copy,flow,omitempty" json:"copy,omitempty"`
 }
 
 // IncludeCopyConfig represents a mapping configuration for a single path in a
 // repository to its destination path in a package.
+// If the Only field is non-empty, only files matching at least one of its
+// patterns (and none of the Ignore patterns) are copied.
+// The IgnoreStyle field selects how Ignore and Only patterns are interpreted,
+// either as regular expressions ("regex", the default) or as shell globs
+// ("glob"). Patterns are case-sensitive unless IgnoreCaseInsensitive is true.
+// If the When field is non-empty, the copy is performed only if its condition
+// (e.g., `$FLAG == "1"`) evaluates true. Conditions may reference the same
+// variables as every other package field, including repository revisions.
+// The Package field may list several destinations, each receiving a copy.
+// If the DirMode or FileMode fields are non-empty, each copied directory or
+// file, respectively, has its permissions set to that octal mode (e.g., "755").
+// If the Verify field is true, files whose content (SHA-256) is identical to an
+// existing destination file are not copied.
+// If the Flatten field is true, all files are copied directly into the package
+// path, discarding source subdirectories; files with the same name are handled
+// according to the Collision field: "error" (default), "skip", or "suffix".
+// If the MaxFileSize field is non-empty, files in a copied directory that are
+// larger than that size (e.g., "100MB"; see ParseSize) are not copied.
+// If the ModifiedSince field is non-empty, only files modified at or after that
+// time (e.g., "2024-01-02T15:04:05Z" or "24h" ago; see ParseSince) are copied.
+// If the PreserveOwner field is true, each copied file and directory is owned
+// by the same user and group as its source, if permitted (not with Flatten,
+// and never on Windows).
+// If the Symlink field is true, each file is not copied, but instead linked by
+// a symbolic link to its absolute source path (not with Flatten). This differs
+// from the Symlinks field, which selects how symbolic links in the source are
+// copied. A package containing links depends on its sources and therefore is
+// not relocatable, so it is never archived unless links are dereferenced.
 type IncludeCopyConfig struct {
-	Repo     string   `yaml:"repo"`
-	Package  string   `yaml:"package"`
-	Conflict string   `yaml:"conflict,omitempty"`
-	Symlinks string   `yaml:"symlinks,omitempty"`
-	Ignore   []string `yaml:"ignore,flow,omitempty"`
+	Repo                  string   `yaml:"repo" json:"repo"`
+	Package               PathList `yaml:"package,flow" json:"package"`
+	Conflict              string   `yaml:"conflict,omitempty" json:"conflict,omitempty"`
+	Symlinks              string   `yaml:"symlinks,omitempty" json:"symlinks,omitempty"`
+	Ignore                []string `yaml:"ignore,flow,omitempty" json:"ignore,omitempty"`
+	Only                  []string `yaml:"only,flow,omitempty" json:"only,omitempty"`
+	IgnoreStyle           string   `yaml:"ignoreStyle,omitempty" json:"ignoreStyle,omitempty"`
+	When                  string   `yaml:"when,omitempty" json:"when,omitempty"`
+	DirMode               string   `yaml:"dirMode,omitempty" json:"dirMode,omitempty"`
+	FileMode              string   `yaml:"fileMode,omitempty" json:"fileMode,omitempty"`
+	Verify                bool     `yaml:"verify,omitempty" json:"verify,omitempty"`
+	Flatten               bool     `yaml:"flatten,omitempty" json:"flatten,omitempty"`
+	Collision             string   `yaml:"collision,omitempty" json:"collision,omitempty"`
+	MaxFileSize           string   `yaml:"maxFileSize,omitempty" json:"maxFileSize,omitempty"`
+	ModifiedSince         string   `yaml:"modifiedSince,omitempty" json:"modifiedSince,omitempty"`
+	PreserveOwner         bool     `yaml:"preserveOwner,omitempty" json:"preserveOwner,omitempty"`
+	IgnoreCaseInsensitive bool     `yaml:"ignoreCaseInsensitive,omitempty" json:"ignoreCaseInsensitive,omitempty"`
+	Symlink               bool     `yaml:"symlink,omitempty" json:"symlink,omitempty"`
+}
+
+// PathList represents a list of paths that may be written in the configuration
+// file as either a single string or a list of strings. An empty string is
+// equivalent to an empty list.
+type PathList []string
+
+// UnmarshalYAML decodes the receiver from either a YAML scalar or sequence.
+func (p *PathList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var path string
+		if err := value.Decode(&path); nil != err {
+			return err
+		}
+		*p = nil
+		if path != "" {
+			*p = PathList{path}
+		}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); nil != err {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// MarshalYAML encodes the receiver as a YAML scalar if it contains exactly one
+// path; otherwise, it is encoded as a sequence.
+func (p PathList) MarshalYAML() (interface{}, error) {
+	if len(p) == 1 {
+		return p[0], nil
+	}
+	return []string(p), nil
+}
+
+// UnmarshalJSON decodes the receiver from either a JSON string or array.
+func (p *PathList) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); nil == err {
+		*p = nil
+		if path != "" {
+			*p = PathList{path}
+		}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); nil != err {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// MarshalJSON encodes the receiver as a JSON string if it contains exactly one
+// path; otherwise, it is encoded as an array.
+func (p PathList) MarshalJSON() ([]byte, error) {
+	if len(p) == 1 {
+		return json.Marshal(p[0])
+	}
+	return json.Marshal([]string(p))
 }
 
 // CompressConfig represents the configuration for a single compressed archive.
+// The Checksum field lists the hash algorithms ("sha256", "md5") used to write
+// sidecar checksum files next to the archive. Each checksum is also defined in
+// the shell environment as $REPO_<name>_<ALGO>, where name is the base name of
+// the package path.
+// If the Reproducible field is true, the archive is written such that identical
+// content always produces identical bytes, with entries sorted by path and all
+// timestamps set to $SOURCE_DATE_EPOCH (or 1980-01-01 if undefined).
+// The TopLevelFolder field names the folder containing all archive entries:
+// by default (empty or "false"), it is the base name of the package directory;
+// if "true", it is the base name of the archive file without its extension;
+// otherwise, it is the given relative path.
+// If the ContinueOnError field is true, files that cannot be archived (e.g.,
+// unreadable files or sockets) are skipped with a warning instead of failing
+// the archive; the package still fails unless keeping going after errors.
+// If the Direct field is true, included content is archived directly from the
+// working copies, without copying it into the package directory, unless the
+// package requires it (e.g., a changelog or roster, or copy options other than
+// ignore/only filtering).
+// If the Append field is true and the output archive already exists, entries
+// of the package are added to it, replacing existing entries of the same name,
+// rather than rebuilding it. Only uncompressed formats (tar and zip) support
+// appending; other formats are rebuilt with a warning. The existing entries of
+// a zip archive are copied without being recompressed (if built with Go 1.17
+// or later), but the archive file is still rewritten entirely.
+// If the Store field is true, the entries of a zip archive are stored without
+// compression, rather than deflated. Otherwise, only entries whose extension
+// indicates already compressed content (e.g., ".jpg" or ".gz") are stored.
+// Store applies only to the zip method, and it may not be combined with a
+// compression level.
+// The Order field sorts the entries of the archive by "path", "size", or
+// "mtime" (modification time), ascending, with ties sorted by path. Unlike
+// Reproducible, it does not change the metadata of any entry. Setting it
+// requires a list of every file to be staged and sorted before any is
+// archived, rather than archiving files in the order they are found.
+// The CreateDirs field controls whether missing directories of the output path
+// are created. It is enabled by default; if disabled, archiving fails unless
+// the directory of the output path already exists.
 type CompressConfig struct {
-	Output    string `yaml:"output"`
-	Overwrite bool   `yaml:"overwrite"`
-	Method    string `yaml:"method"`
-	Level     int    `yaml:"level"`
+	Output          string   `yaml:"output" json:"output"`
+	Overwrite       bool     `yaml:"overwrite" json:"overwrite"`
+	Method          string   `yaml:"method" json:"method"`
+	Level           int      `yaml:"level" json:"level"`
+	Checksum        []string `yaml:"checksum,flow,omitempty" json:"checksum,omitempty"`
+	Reproducible    bool     `yaml:"reproducible,omitempty" json:"reproducible,omitempty"`
+	TopLevelFolder  string   `yaml:"topLevelFolder,omitempty" json:"topLevelFolder,omitempty"`
+	Direct          bool     `yaml:"direct,omitempty" json:"direct,omitempty"`
+	ContinueOnError bool     `yaml:"continueOnError,omitempty" json:"continueOnError,omitempty"`
+	Append          bool     `yaml:"append,omitempty" json:"append,omitempty"`
+	Store           bool     `yaml:"store,omitempty" json:"store,omitempty"`
+	Order           string   `yaml:"order,omitempty" json:"order,omitempty"`
+	CreateDirs      *bool    `yaml:"createDirs,omitempty" json:"createDirs,omitempty"`
+}
+
+// CreatesDirs returns true unless CreateDirs is explicitly false.
+func (c *CompressConfig) CreatesDirs() bool {
+	return nil == c.CreateDirs || *c.CreateDirs
 }
 
+// Stdin is the configuration file path that refers to standard input.
+const Stdin = "-"
+
 // Parse parses the configuration file into the returned Config struct.
+// If the path is Stdin, the configuration is read from standard input instead.
+// The file format (YAML or JSON) is determined by the file extension; if the
+// extension is not recognized, YAML is attempted first and then JSON.
+// Files listed in the top-level include field are parsed and merged beneath the
+// including file; see resolveIncludes.
+// If the given path is a directory, each configuration file it contains is
+// parsed and merged; see parseDir.
 // Returns a nil Config and descriptive error if the given path is invalid or
 // the configuration file could not be parsed.
 func Parse(filePath string) (*Config, error) {
 
+	if filePath == Stdin {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if nil != err {
+			return nil, err
+		}
+		format := UnknownFormat
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			format = JSON
+		}
+		return parseData(filePath, format, data)
+	}
+
 	dir := filepath.Dir(filePath)
 	dstat, derr := os.Stat(dir)
 	if os.IsNotExist(derr) {
@@ -156,6 +522,8 @@ func Parse(filePath string) (*Config, error) {
 	fstat, ferr := os.Stat(filePath)
 	if os.IsNotExist(ferr) {
 		return nil, ConfigFileNotFoundError(filePath)
+	} else if nil == ferr && fstat.IsDir() {
+		return parseDir(filePath)
 	} else if uint32(fstat.Mode()&os.ModeType) != 0 {
 		return nil, NotRegularFileError(filePath)
 	}
@@ -165,26 +533,332 @@ func Parse(filePath string) (*Config, error) {
 		return nil, err
 	}
 
-	cfg := &Config{path: filePath}
+	return parseData(filePath, FormatOf(filePath), data)
+}
 
-	err = yaml.Unmarshal(data, cfg)
+// parseData parses the given configuration file content, read from filePath,
+// in the given format into the returned Config struct.
+func parseData(filePath string, format Format, data []byte) (*Config, error) {
+
+	var err error
+	cfg := &Config{path: filePath, format: format, source: data}
+
+	switch cfg.format {
+	case JSON:
+		err = json.Unmarshal(data, cfg)
+	case YAML:
+		err = yaml.Unmarshal(data, cfg)
+	default:
+		// unknown format, try YAML first and then JSON.
+		if err = yaml.Unmarshal(data, cfg); err == nil {
+			cfg.format = YAML
+		} else if err = json.Unmarshal(data, cfg); err == nil {
+			cfg.format = JSON
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if len(cfg.Include) > 0 {
+		if err := cfg.resolveIncludes(); nil != err {
+			return nil, err
+		}
+	}
+
 	return cfg, nil
 }
 
-// Write formats and writes the receiver configuration to disk.
-// Returns an error if formatting or writing fails.
-func (cfg *Config) Write() error {
-	data, err := yaml.Marshal(cfg)
+// Validate verifies that all required fields of the receiver are defined and
+// that all compression levels are in range for their respective methods.
+// Returns a ValidationError listing every problem found, or nil if there are
+// no problems.
+func (cfg *Config) Validate() error {
+	var errs ValidationError
+	for _, name := range sortedKeys(cfg.Export) {
+		if cfg.Export[name].Repo == "" {
+			errs = append(errs, fmt.Sprintf("export %q: missing repo", name))
+		}
+		switch mode := cfg.Export[name].Mode; strings.ToLower(mode) {
+		case "", "checkout", "export":
+		default:
+			errs = append(errs, fmt.Sprintf(
+				"export %q: invalid mode %q (expected checkout or export)", name, mode))
+		}
+		switch depth := cfg.Export[name].Depth; depth {
+		case "", "empty", "files", "immediates", "infinity":
+		default:
+			errs = append(errs, fmt.Sprintf(
+				"export %q: invalid depth %q (expected empty, files, immediates, or infinity)", name, depth))
+		}
+		for _, p := range cfg.Export[name].Paths {
+			if p == "" || path.IsAbs(p) || path.Clean(p) == "." ||
+				strings.HasPrefix(path.Clean(p), "../") || path.Clean(p) == ".." {
+				errs = append(errs, fmt.Sprintf(
+					"export %q: invalid path %q (expected relative subtree of repository path)", name, p))
+			}
+		}
+		if mirror := cfg.Export[name].Mirror; urlProtocol.MatchString(mirror) {
+			errs = append(errs, fmt.Sprintf(
+				"export %q: invalid mirror %q (expected local directory, remote mirrors are not supported)", name, mirror))
+		}
+	}
+	pkgs := make([]string, 0, len(cfg.Package))
+	for name := range cfg.Package {
+		pkgs = append(pkgs, name)
+	}
+	sort.Strings(pkgs)
+	for _, name := range pkgs {
+		pkg := cfg.Package[name]
+		for _, inc := range pkg.Include {
+			for src, list := range inc {
+				for i, op := range list {
+					if op.Copy.Repo != "" && len(op.Copy.Package) == 0 {
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: missing package", name, src, i+1))
+					}
+					switch strings.ToLower(op.Copy.Collision) {
+					case "", "error", "skip", "suffix":
+					default:
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: invalid collision %q (expected error, skip, or suffix)",
+							name, src, i+1, op.Copy.Collision))
+					}
+					if op.Copy.PreserveOwner && op.Copy.Flatten {
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: preserveOwner is not supported with flatten",
+							name, src, i+1))
+					}
+					if pkg.Prune && (op.Copy.ModifiedSince != "" ||
+						strings.EqualFold(op.Copy.Symlinks, "deep")) {
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: prune is not supported with modifiedSince or symlinks: deep",
+							name, src, i+1))
+					}
+					if op.Copy.Symlink && op.Copy.Flatten {
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: symlink is not supported with flatten",
+							name, src, i+1))
+					}
+					if _, err := ParseMode(op.Copy.DirMode); nil != err {
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: invalid dirMode %q (expected octal, e.g., \"755\")",
+							name, src, i+1, op.Copy.DirMode))
+					}
+					if _, err := ParseSince(op.Copy.ModifiedSince, time.Now()); nil != err {
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: invalid modifiedSince %q (expected RFC3339 time or duration, e.g., \"24h\")",
+							name, src, i+1, op.Copy.ModifiedSince))
+					}
+					if _, err := ParseSize(op.Copy.MaxFileSize); nil != err {
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: invalid maxFileSize %q (expected bytes, e.g., \"100MB\")",
+							name, src, i+1, op.Copy.MaxFileSize))
+					}
+					if _, err := ParseMode(op.Copy.FileMode); nil != err {
+						errs = append(errs, fmt.Sprintf(
+							"package %q: include %q: copy %d: invalid fileMode %q (expected octal, e.g., \"644\")",
+							name, src, i+1, op.Copy.FileMode))
+					}
+				}
+			}
+		}
+		if top := pkg.Compress.TopLevelFolder; top != "" {
+			if clean := path.Clean(filepath.ToSlash(top)); filepath.IsAbs(top) || path.IsAbs(clean) ||
+				clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+				errs = append(errs, fmt.Sprintf(
+					"package %q: invalid compress topLevelFolder %q (expected relative path, true, or false)",
+					name, top))
+			}
+		}
+		switch order := pkg.Compress.Order; strings.ToLower(order) {
+		case "", "path", "size", "mtime":
+		default:
+			errs = append(errs, fmt.Sprintf(
+				"package %q: invalid compress order %q (expected path, size, or mtime)", name, order))
+		}
+		if pkg.Compress.Store {
+			switch strings.ToLower(pkg.Compress.Method) {
+			case "zip", ".zip":
+				if pkg.Compress.Level != 0 {
+					errs = append(errs, fmt.Sprintf(
+						"package %q: compress store and level %d are mutually exclusive",
+						name, pkg.Compress.Level))
+				}
+			default:
+				errs = append(errs, fmt.Sprintf(
+					"package %q: compress store requires method zip, not %q (tar methods are compressed as a whole)",
+					name, pkg.Compress.Method))
+			}
+		}
+		if lev := pkg.Compress.Level; lev != 0 {
+			if lo, hi, ok := LevelRange(pkg.Compress.Method); ok && (lev < lo || lev > hi) {
+				errs = append(errs, fmt.Sprintf(
+					"package %q: compress level %d out of range [%d, %d] for method %q",
+					name, lev, lo, hi, pkg.Compress.Method))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// LevelRange returns the range of valid compression levels for the given
+// compression method. Level 0 is always valid and selects the method's default
+// level, regardless of range. Returns ok false if the method does not support
+// compression levels or is not recognized.
+func LevelRange(method string) (lo, hi int, ok bool) {
+	switch strings.ToLower(method) {
+	case "zip", ".zip",
+		"gz", ".gz", "tgz", ".tgz", "targz", "tar.gz", ".tar.gz":
+		return -2, 9, true // flate.HuffmanOnly to flate.BestCompression
+	case "bz2", ".bz2", "tbz", ".tbz", "tbz2", ".tbz2", "tarbz2", "tar.bz2", ".tar.bz2":
+		return 1, 9, true
+	}
+	return 0, 0, false
+}
+
+// ParseMode returns the file permission bits represented by the given octal
+// string (e.g., "755" or "0644"). An empty string returns a zero mode, meaning
+// permissions are not modified.
+func ParseMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
 	if nil != err {
-		return err
+		return 0, err
+	}
+	if perm > uint64(os.ModePerm) {
+		return 0, fmt.Errorf("mode out of range: %s", mode)
+	}
+	return os.FileMode(perm), nil
+}
+
+// sizeUnit contains the multiplier of each recognized size suffix, longest
+// first. Suffixes with "i" are powers of 1024; those without are powers of
+// 1000, except for single letters, which are powers of 1024 by convention.
+var sizeUnit = []struct {
+	suffix string
+	mult   int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+// ParseSize parses the given size, a non-negative integer number of bytes with
+// an optional unit suffix (e.g., "512", "100MB", "1GiB", "64k"), case
+// insensitive. An empty size returns 0.
+func ParseSize(size string) (int64, error) {
+	num, mult := strings.ToLower(strings.TrimSpace(size)), int64(1)
+	if num == "" {
+		return 0, nil
+	}
+	for _, u := range sizeUnit {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if nil != err || n < 0 || n > (1<<63-1)/mult {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return n * mult, nil
+}
+
+// ParseSince parses the given time, either an RFC3339 timestamp or a duration
+// (e.g., "24h" or "90m") before the given current time. An empty time returns
+// the zero time.
+func ParseSince(since string, now time.Time) (time.Time, error) {
+	since = strings.TrimSpace(since)
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); nil == err {
+		return t, nil
+	}
+	d, err := time.ParseDuration(since)
+	if nil != err || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid time: %s", since)
+	}
+	return now.Add(-d), nil
+}
+
+// sortedKeys returns the keys of the given ExportMap in sorted order.
+func sortedKeys(m ExportMap) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Marshal formats the receiver configuration in the same format from which it
+// was parsed (YAML by default).
+func (cfg *Config) Marshal() ([]byte, error) {
+	if cfg.format == JSON {
+		data, err := json.MarshalIndent(cfg, "", "  ")
+		if nil != err {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return yaml.Marshal(cfg)
+}
+
+// Write formats and writes the receiver configuration to the file from which
+// it was parsed. See WriteFile for details.
+func (cfg *Config) Write() error {
+	return cfg.WriteFile(cfg.path)
+}
+
+// WriteFile formats and writes the receiver configuration to the given path.
+// The format is determined by the extension of path, or is the format from
+// which the receiver was parsed if the extension is not recognized.
+// YAML configuration is updated in place, such that only the changed revision
+// ("last") fields are modified, and all comments, key ordering, and anchors
+// in the original document are preserved.
+// An existing file retains its permissions.
+// A configuration parsed from a directory is written back to that directory by
+// updating each file that defines an updated export; written to any other
+// path, it is formatted as a single file.
+// Returns an error if formatting or writing fails.
+func (cfg *Config) WriteFile(filePath string) error {
+	if filePath == Stdin {
+		return InvalidPathError(filePath)
+	}
+	if len(cfg.parts) > 0 && filepath.Clean(filePath) == filepath.Clean(cfg.path) {
+		return cfg.writeParts()
+	}
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(filePath); nil == err {
+		perm = info.Mode().Perm()
+	}
+	format := FormatOf(filePath)
+	if format == UnknownFormat {
+		format = cfg.format
+	}
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case format == JSON:
+		data, err = json.MarshalIndent(cfg, "", "  ")
+		data = append(data, '\n')
+	case cfg.format == JSON, len(cfg.parts) > 0:
+		data, err = yaml.Marshal(cfg)
+	default:
+		data, err = cfg.updateDocument()
 	}
-	info, err := os.Stat(cfg.path)
 	if nil != err {
 		return err
 	}
-	return ioutil.WriteFile(cfg.path, data, info.Mode().Perm())
+	return ioutil.WriteFile(filePath, data, perm)
 }
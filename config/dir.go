@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// parseDir parses each configuration file in the given directory, in order of
+// file name, and returns their content merged into a single Config.
+// Files are recognized by extension (see FormatOf), except for hidden files
+// and files included by another file in the directory, which are merged only
+// by the including file.
+// Unlike included files, the files of a directory are peers: an export or
+// package defined by more than one file, conflicting cacheDir fields, or svn
+// fields defined by more than one file, are a ValidationError.
+func parseDir(dirPath string) (*Config, error) {
+
+	infos, err := ioutil.ReadDir(dirPath)
+	if nil != err {
+		return nil, err
+	}
+
+	parts := map[string]*Config{}
+	included := map[string]bool{}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") ||
+			FormatOf(name) == UnknownFormat {
+			continue
+		}
+		part, err := Parse(filepath.Join(dirPath, name))
+		if nil != err {
+			return nil, err
+		}
+		parts[name] = part
+		for _, inc := range part.Include {
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(dirPath, inc)
+			}
+			if filepath.Dir(filepath.Clean(inc)) == filepath.Clean(dirPath) {
+				included[filepath.Base(inc)] = true
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return nil, ConfigFileNotFoundError(filepath.Join(dirPath, "*.yml"))
+	}
+
+	names := make([]string, 0, len(parts))
+	for name := range parts {
+		if !included[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var errs ValidationError
+	cfg := &Config{path: dirPath, format: YAML, Export: ExportMap{}, Package: PackageMap{}}
+	defined := map[string]string{}
+	svnDefined := ""
+	for _, name := range names {
+		part := parts[name]
+		if part.CacheDir != "" {
+			if cfg.CacheDir != "" && cfg.CacheDir != part.CacheDir {
+				errs = append(errs, fmt.Sprintf("%s: cacheDir %q conflicts with %q",
+					name, part.CacheDir, cfg.CacheDir))
+			}
+			cfg.CacheDir = part.CacheDir
+		}
+		if part.Svn.Path != "" || len(part.Svn.Args) > 0 {
+			if cfg.Svn.Path != "" || len(cfg.Svn.Args) > 0 {
+				errs = append(errs, fmt.Sprintf("%s: svn conflicts with %s", name, svnDefined))
+			}
+			cfg.Svn, svnDefined = part.Svn, name
+		}
+		for _, key := range sortedKeys(part.Export) {
+			if prev, ok := defined["export "+key]; ok {
+				errs = append(errs, fmt.Sprintf("export %q: defined in both %s and %s", key, prev, name))
+			}
+			defined["export "+key] = name
+			cfg.Export[key] = part.Export[key]
+		}
+		for key, pkg := range part.Package {
+			if prev, ok := defined["package "+key]; ok {
+				errs = append(errs, fmt.Sprintf("package %q: defined in both %s and %s", key, prev, name))
+			}
+			defined["package "+key] = name
+			cfg.Package[key] = pkg
+		}
+		cfg.parts = append(cfg.parts, part)
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, errs
+	}
+	return cfg, nil
+}
+
+// writeParts writes the updated revision of each export of the receiver, which
+// was parsed from a directory, to the file in that directory defining it.
+// Files without any updated revision are not written.
+func (cfg *Config) writeParts() error {
+	for _, part := range cfg.parts {
+		changed := false
+		for key, expo := range part.Export {
+			if last := cfg.Export[key].Last; last != expo.Last {
+				expo.Last = last
+				part.Export[key] = expo
+				changed = true
+			}
+		}
+		if changed {
+			if err := part.Write(); nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
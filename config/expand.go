@@ -0,0 +1,64 @@
+package config
+
+// Expand returns a copy of the receiver with the given substitution function
+// (e.g., variable expansion) applied to each field that supports variables:
+// Repo, Path, Local, Username, Password, Revision, and Mirror.
+func (e ExportConfig) Expand(subst func(string) string) ExportConfig {
+	e.Repo = subst(e.Repo)
+	e.Path = subst(e.Path)
+	e.Local = subst(e.Local)
+	e.Username = subst(e.Username)
+	e.Password = subst(e.Password)
+	e.Revision = subst(e.Revision)
+	e.Mirror = subst(e.Mirror)
+	return e
+}
+
+// Expand returns a copy of the receiver with the given substitution function
+// applied to each field that supports variables: Repo, Package, Ignore, and
+// Only. The When field is substituted only when its condition is evaluated.
+func (c IncludeCopyConfig) Expand(subst func(string) string) IncludeCopyConfig {
+	c.Repo = subst(c.Repo)
+	c.Package = expandAll(subst, c.Package)
+	c.Ignore = expandAll(subst, c.Ignore)
+	c.Only = expandAll(subst, c.Only)
+	return c
+}
+
+// Expand returns a copy of the receiver with the given substitution function
+// applied to each field that supports variables: the repository (or path) of
+// each include, each of its copy operations (see IncludeCopyConfig.Expand),
+// each hook command, and the compress output path.
+func (p PackageConfig) Expand(subst func(string) string) PackageConfig {
+	p.PreHook = expandAll(subst, p.PreHook)
+	p.PostHook = expandAll(subst, p.PostHook)
+	if nil != p.Include {
+		include := make(IncludeList, len(p.Include))
+		for i, inc := range p.Include {
+			include[i] = IncludeMap{}
+			for path, list := range inc {
+				ops := make(IncludePathList, len(list))
+				for j, op := range list {
+					ops[j] = IncludePathOp{Copy: op.Copy.Expand(subst)}
+				}
+				include[i][subst(path)] = ops
+			}
+		}
+		p.Include = include
+	}
+	p.Compress.Output = subst(p.Compress.Output)
+	return p
+}
+
+// expandAll returns a new slice containing the result of the given
+// substitution function applied to each element of the given slice.
+func expandAll(subst func(string) string, s []string) []string {
+	if nil == s {
+		return nil
+	}
+	x := make([]string, len(s))
+	for i, v := range s {
+		x[i] = subst(v)
+	}
+	return x
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeKey is the top-level key listing the files included by a
+// configuration file.
+const includeKey = "include"
+
+// resolveIncludes replaces the receiver's content with the content of each of
+// its included files merged in order, followed by its own content merged last.
+// Mappings are merged recursively, so that a later definition overrides only
+// the fields it defines (e.g., an export may be redefined with a different
+// revision); all other values (including lists) are replaced entirely.
+// Included files may include other files, relative to their own directory, but
+// a file may not include itself directly or indirectly.
+func (cfg *Config) resolveIncludes() error {
+	dir, stack := ".", []string{}
+	if cfg.path != Stdin {
+		abs, err := filepath.Abs(cfg.path)
+		if nil != err {
+			return err
+		}
+		dir, stack = filepath.Dir(abs), []string{abs}
+	}
+	merged, err := mergeIncludes(dir, cfg.Include, stack)
+	if nil != err {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(cfg.source, &doc); nil != err {
+		return err
+	}
+	if root := documentRoot(&doc); nil != root {
+		deleteMappingKey(root, includeKey)
+		mergeNode(merged, root)
+	}
+	parsed := &Config{path: cfg.path, format: cfg.format, source: cfg.source}
+	if err := merged.Decode(parsed); nil != err {
+		return err
+	}
+	parsed.Include = cfg.Include
+	*cfg = *parsed
+	return nil
+}
+
+// mergeIncludes returns a mapping node containing the content of each of the
+// given files, relative to dir, merged in order. The stack contains the
+// absolute paths of all files currently being included, to detect cycles.
+func mergeIncludes(dir string, include []string, stack []string) (*yaml.Node, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, inc := range include {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(dir, inc)
+		}
+		abs, err := filepath.Abs(inc)
+		if nil != err {
+			return nil, err
+		}
+		for _, s := range stack {
+			if s == abs {
+				return nil, IncludeCycleError(strings.Join(append(stack, abs), " -> "))
+			}
+		}
+		root, err := includeRoot(abs)
+		if nil != err || nil == root {
+			return nil, err
+		}
+		var nested PathList
+		if val := mappingValue(root, includeKey); nil != val {
+			if err := val.Decode(&nested); nil != err {
+				return nil, fmt.Errorf("%s: %v", inc, err)
+			}
+			deleteMappingKey(root, includeKey)
+		}
+		node, err := mergeIncludes(filepath.Dir(abs), nested, append(stack, abs))
+		if nil != err {
+			return nil, err
+		}
+		mergeNode(node, root)
+		mergeNode(merged, node)
+	}
+	return merged, nil
+}
+
+// includeRoot reads and parses the included configuration file at the given
+// path, returning its root mapping node, or nil if the file is empty.
+func includeRoot(filePath string) (*yaml.Node, error) {
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return nil, ConfigFileNotFoundError(filePath)
+	} else if nil != err {
+		return nil, err
+	} else if !info.Mode().IsRegular() {
+		return nil, NotRegularFileError(filePath)
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if nil != err {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); nil != err {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+	root := documentRoot(&doc)
+	if nil != root && root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: included configuration is not a mapping", filePath)
+	}
+	return root, nil
+}
+
+// mergeNode merges the given src mapping node into the dst mapping node.
+// Keys whose values are mappings in both nodes are merged recursively; all
+// other values in src replace those in dst, or are appended to dst if the key
+// does not exist.
+func mergeNode(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, val := src.Content[i], src.Content[i+1]
+		if cur := mappingValue(dst, key.Value); nil != cur {
+			if cur.Kind == yaml.MappingNode && val.Kind == yaml.MappingNode {
+				mergeNode(cur, val)
+			} else {
+				*cur = *val
+			}
+			continue
+		}
+		dst.Content = append(dst.Content, key, val)
+	}
+}
+
+// deleteMappingKey removes the given key and its value from the given mapping
+// node, if it exists.
+func deleteMappingKey(node *yaml.Node, key string) {
+	if nil == node || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"bytes"
+
+	"gopkg.in/yaml.v3"
+)
+
+// updateDocument parses the receiver's original content as a YAML document
+// and returns it re-encoded with the "last" field of each export updated to
+// the receiver's value, if changed.
+// All other content, including comments, key ordering, and anchors, is
+// preserved. An export whose "last" field is inherited through an alias or a
+// merge key ("<<") is given its own "last" field only if its value differs, and
+// an export defined entirely by an alias becomes a mapping that merges the
+// aliased node, such that the anchored definition is never duplicated.
+// Exports defined only in included files are recorded in the receiver's
+// document as partial definitions containing only the "last" field, which
+// override the included definitions when merged. Included files are never
+// modified, since they may be shared by several configurations.
+func (cfg *Config) updateDocument() ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(cfg.source, &doc); nil != err {
+		return nil, err
+	}
+	root := documentRoot(&doc)
+	export := mappingValue(root, "export")
+	if nil != export && export.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(export.Content); i += 2 {
+			name, node := export.Content[i].Value, export.Content[i+1]
+			expo, ok := cfg.Export[name]
+			if !ok || mergedValue(node, "last") == expo.Last {
+				continue
+			}
+			if node.Kind == yaml.AliasNode {
+				node = mergeAlias(export, i+1)
+			}
+			if node.Kind == yaml.MappingNode {
+				setMappingValue(node, "last", expo.Last)
+			}
+		}
+	}
+	if len(cfg.Include) > 0 && nil != root && root.Kind == yaml.MappingNode {
+		for _, name := range sortedKeys(cfg.Export) {
+			last := cfg.Export[name].Last
+			if last == "" || nil != mappingValue(export, name) {
+				continue
+			}
+			export = setMappingNode(root, "export")
+			setMappingValue(setMappingNode(export, name), "last", last)
+		}
+	}
+	untagMerge(&doc)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(4)
+	if err := enc.Encode(&doc); nil != err {
+		return nil, err
+	}
+	if err := enc.Close(); nil != err {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// documentRoot returns the root content node of the given document node, or
+// nil if the document is empty.
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		return doc.Content[0]
+	}
+	return doc
+}
+
+// mappingValue returns the value node associated with the given key in the
+// given mapping node, or nil if the key does not exist or node is not a
+// mapping.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if nil == node || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mergedValue returns the string value associated with the given key in the
+// given mapping node, following aliases and merge keys ("<<") as they are
+// resolved when decoding, or the empty string if the key does not exist.
+func mergedValue(node *yaml.Node, key string) string {
+	for nil != node && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if val := mappingValue(node, key); nil != val {
+		for val.Kind == yaml.AliasNode && nil != val.Alias {
+			val = val.Alias
+		}
+		return val.Value
+	}
+	merge := mappingValue(node, "<<")
+	if nil == merge {
+		return ""
+	}
+	if merge.Kind == yaml.SequenceNode {
+		// Earlier nodes in a merge sequence take precedence over later nodes.
+		for _, m := range merge.Content {
+			if v := mergedValue(m, key); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	return mergedValue(merge, key)
+}
+
+// mergeAlias replaces the alias node at the given index of the given mapping
+// node's content with a mapping that merges the aliased node ("<<: *anchor"),
+// and returns the new mapping.
+func mergeAlias(node *yaml.Node, index int) *yaml.Node {
+	val := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: "<<"},
+		node.Content[index],
+	}}
+	node.Content[index] = val
+	return val
+}
+
+// untagMerge clears the explicit tag of each merge key ("<<") in the given
+// node and its descendants, which the encoder would otherwise emit verbatim
+// ("!!merge <<"). Untagged merge keys are resolved again when decoding.
+func untagMerge(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!merge" {
+		node.Tag = ""
+	}
+	for _, n := range node.Content {
+		untagMerge(n)
+	}
+}
+
+// setMappingValue sets the string value associated with the given key in the
+// given mapping node, appending the key if it does not exist.
+// The node is not modified if the key's value is unchanged, or if the key does
+// not exist and the value is empty.
+func setMappingValue(node *yaml.Node, key, value string) {
+	if val := mappingValue(node, key); nil != val {
+		if val.Value != value {
+			val.Kind, val.Tag, val.Value, val.Style = yaml.ScalarNode, "!!str", value, 0
+		}
+		return
+	}
+	if value == "" {
+		return
+	}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// setMappingNode returns the mapping node associated with the given key in the
+// given mapping node, appending the key with an empty mapping if it does not
+// exist or is not a mapping.
+func setMappingNode(node *yaml.Node, key string) *yaml.Node {
+	if val := mappingValue(node, key); nil != val {
+		if val.Kind != yaml.MappingNode {
+			*val = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		return val
+	}
+	val := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, val)
+	return val
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the given content to a new configuration file and
+// returns its parsed Config.
+func writeConfig(t *testing.T, content string) (string, *Config) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "svngrab.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); nil != err {
+		t.Fatal(err)
+	}
+	cfg, err := Parse(path)
+	if nil != err {
+		t.Fatalf("Parse: %v", err)
+	}
+	return path, cfg
+}
+
+// updateLast sets the last revision of the named export of the given Config,
+// writes it back to path, and returns the written content.
+func updateLast(t *testing.T, path string, cfg *Config, name, last string) string {
+	t.Helper()
+	expo := cfg.Export[name]
+	expo.Last = last
+	cfg.Export[name] = expo
+	if err := cfg.Write(); nil != err {
+		t.Fatalf("Write: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUpdateDocumentPreservesComments(t *testing.T) {
+	const original = `# repositories to export
+export:
+    # the first repository
+    zeta:
+        repo: https://example.com/svn/zeta
+        path: trunk
+        local: ./zeta # working copy
+        last: "100"
+    alpha:
+        repo: https://example.com/svn/alpha
+        path: trunk
+        local: ./alpha
+        last: "200"
+    mid:
+        local: ./mid
+        path: trunk
+        repo: https://example.com/svn/mid
+# no packages yet
+`
+	path, cfg := writeConfig(t, original)
+	got := updateLast(t, path, cfg, "alpha", "201")
+
+	want := strings.Replace(original, `last: "200"`, `last: "201"`, 1)
+	if got != want {
+		t.Errorf("written configuration:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUpdateDocumentPreservesAnchors(t *testing.T) {
+	const original = `export:
+    base: &base
+        repo: https://example.com/svn/base
+        path: trunk
+        local: ./base
+        last: "100"
+    copy: *base
+    merged:
+        <<: *base
+        local: ./merged
+`
+	path, cfg := writeConfig(t, original)
+
+	// update the exports defined by alias and by merge key, each of which would
+	// otherwise change the anchored node shared with the other exports.
+	for _, name := range []string{"copy", "merged"} {
+		expo := cfg.Export[name]
+		expo.Last = "101"
+		cfg.Export[name] = expo
+	}
+	got := updateLast(t, path, cfg, "merged", "102")
+
+	const want = `export:
+    base: &base
+        repo: https://example.com/svn/base
+        path: trunk
+        local: ./base
+        last: "100"
+    copy:
+        <<: *base
+        last: "101"
+    merged:
+        <<: *base
+        local: ./merged
+        last: "102"
+`
+	if got != want {
+		t.Errorf("written configuration:\n%s\nwant:\n%s", got, want)
+	}
+
+	cfg, err := Parse(path)
+	if nil != err {
+		t.Fatalf("Parse: %v", err)
+	}
+	for name, last := range map[string]string{"base": "100", "copy": "101", "merged": "102"} {
+		if expo := cfg.Export[name]; expo.Last != last || expo.Repo != "https://example.com/svn/base" {
+			t.Errorf("export %q: last %q, repo %q, want last %q", name, expo.Last, expo.Repo, last)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scaffold is the template of the example configuration file written by
+// Scaffold. Each occurrence of "{{name}}" is replaced with the base name of the
+// directory containing the configuration file.
+const scaffold = `# svngrab configuration file
+#
+# Several fields support variable substitution of the form $VAR, where VAR is
+# defined on the command-line (VAR=VAL), as a builtin (e.g., $DATE), or in the
+# environment. See "svngrab -h" for details.
+
+# include merges other configuration files (relative to this file) beneath this
+# one, in order. definitions in later files, and in this file, override those
+# of earlier files field-by-field.
+#include: [base.yml]
+
+# export declares the repositories to retrieve. each key is a name by which the
+# repository is referenced in the package section below.
+export:
+    {{name}}:
+        # VCS type of the repository: "svn", "git", or empty to auto-detect.
+        type: svn
+        # root URL of the remote repository.
+        repo: https://host/svn/{{name}}
+        # path appended to repo that is retrieved (e.g., trunk or branches/x).
+        path: trunk
+        # local directory into which the working copy is retrieved.
+        local: .svngrab/{{name}}
+        # "checkout" maintains a working copy; "export" retrieves a clean tree
+        # without .svn metadata each run (replacing local entirely).
+        mode: checkout
+        # svn only: limit retrieval to the given depth (empty, files, immediates,
+        # or infinity), and/or to the given subtrees of path (sparse checkout).
+        # changing depth between runs may require removing local for a fresh
+        # checkout, since the depth of an existing working copy is sticky.
+        #depth: infinity
+        #paths: [include, src/lib]
+        # svn only: do not retrieve svn:externals definitions.
+        #ignoreExternals: false
+        # local directory replaced with a copy of the working copy (without VCS
+        # metadata) after each successful export.
+        #mirror: /srv/mirror/{{name}}
+        # the revision last retrieved is recorded here automatically.
+        #last: ""
+
+# package declares the output packages to build. each key is the path of the
+# directory into which content is copied.
+package:
+    ./{{name}}-package:
+        # write a CHANGELOG of commits since the previous export into the package.
+        changelog: false
+        # write a ROSTER.tsv listing every file in the package with its size,
+        # source repository, and revision into the package.
+        roster: false
+        # exclude VCS metadata (.svn, .git, ...) from all copy operations.
+        excludeVcsMeta: true
+        # shell commands run before copying and after archiving, with the shell
+        # environment script variables, $SVNGRAB_PACKAGE, and $SVNGRAB_ARCHIVE
+        # (postHook only) defined in their environment. use \$ to reference
+        # them, so that they are not substituted in the configuration.
+        #preHook: ./codegen.sh
+        #postHook: [gpg --detach-sign "\$SVNGRAB_ARCHIVE"]
+        # include lists the repositories whose content is copied into the package.
+        include:
+            - {{name}}:
+                # repo is a path relative to the working copy, and package is a
+                # path (or list of paths) relative to the package directory, which
+                # it must not escape (absolute paths require -allow-absolute-dest).
+                #   conflict: merge, replace, or skip existing directories.
+                #   symlinks: deep, shallow, or skip symbolic links.
+                #   ignore: regular expressions of paths to exclude, relative to
+                #     repo (e.g., "^build/" excludes only the top-level build).
+                #   ignoreCaseInsensitive: match ignore and only patterns without
+                #     regard to case (e.g., on Windows).
+                #   dirMode, fileMode: octal permissions of copied content.
+                #   preserveOwner: copy the owner of each file, if permitted (no-op
+                #     on Windows).
+                #   verify: skip files identical (SHA-256) to their destination.
+                #   maxFileSize: skip files larger than this size (e.g., 100MB).
+                #   modifiedSince: copy only files modified since this RFC3339
+                #     time, or within this duration (e.g., 24h).
+                #   flatten: copy all files directly into package, without
+                #     subdirectories; collision: error, skip, or suffix.
+                - copy: {repo: ., package: ., conflict: merge, symlinks: skip}
+        # compress creates an archive of the package directory.
+        compress:
+            # archive file path; the extension is corrected to match method.
+            output: ./{{name}}-$DATE.zip
+            # replace the archive if it already exists.
+            overwrite: true
+            # create missing directories of the output path; if false, fail
+            # unless the directory already exists.
+            createDirs: true
+            # archive format: zip, tar, tar.gz, tar.bz2, tar.xz, or tar.zst.
+            method: zip
+            # compression level; valid range depends on method (zip, tar.gz: -2
+            # to 9; tar.bz2: 1 to 9). 0 or omitted selects the method default.
+            level: 9
+            # write identical bytes for identical content (sorted entries, with
+            # timestamps from $SOURCE_DATE_EPOCH or 1980-01-01).
+            reproducible: false
+            # folder containing all archive entries: false (the package directory
+            # name), true (the archive file name without extension), or a name.
+            topLevelFolder: false
+            # archive content directly from the working copies without copying
+            # it into the package directory (ignored if the package needs the
+            # directory, e.g., for a changelog, roster, or preHook).
+            direct: false
+            # skip files that cannot be archived (e.g., unreadable or sockets)
+            # with a warning; the run still fails at the end unless -k.
+            continueOnError: false
+`
+
+// MakeDir creates the directory containing the given configuration file path,
+// along with any missing parents, so that the file may be written by Scaffold
+// or WriteFile, which otherwise require the directory to exist. Parse never
+// creates directories.
+func MakeDir(filePath string) error {
+	if filePath == Stdin {
+		return InvalidPathError(filePath)
+	}
+	return os.MkdirAll(filepath.Dir(filePath), 0755)
+}
+
+// Scaffold writes a commented example configuration file at the given path,
+// using the name of the directory containing it to name the example export
+// and package.
+// Returns FileExistsError if a file already exists at the given path, or
+// DirectoryNotFoundError if its directory does not exist (see MakeDir).
+func Scaffold(filePath string) error {
+
+	if filePath == Stdin {
+		return InvalidPathError(filePath)
+	}
+
+	dir := filepath.Dir(filePath)
+	dstat, derr := os.Stat(dir)
+	if os.IsNotExist(derr) {
+		return DirectoryNotFoundError(dir)
+	} else if !dstat.IsDir() {
+		return InvalidPathError(dir)
+	}
+
+	abs, err := filepath.Abs(dir)
+	if nil != err {
+		return err
+	}
+	name := filepath.Base(abs)
+	data := strings.ReplaceAll(scaffold, "{{name}}", name)
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return FileExistsError(filePath)
+	} else if nil != err {
+		return err
+	}
+	if _, err := f.WriteString(data); nil != err {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -6,11 +6,18 @@ type Level int
 // Constant values of enumerated type Level.
 const (
 	Info Level = iota
+	Warn
 	Error
 )
 
 // Symbol returns a rune representing the receiver Level; intended for use in
 // log messages.
 func (lev Level) Symbol() rune {
-	return []rune(" !")[int(lev)]
+	return []rune(" *!")[int(lev)]
+}
+
+// Color returns the ANSI SGR escape sequence used to colorize log messages of
+// the receiver Level.
+func (lev Level) Color() string {
+	return []string{"\x1b[36m", "\x1b[33m", "\x1b[31m"}[int(lev)]
 }
@@ -3,29 +3,99 @@ package log
 import (
 	"fmt"
 	"io"
+	"os"
+	"time"
 )
 
 // Log represents an object for writing log messages.
 // All messages are written to the io.Writer member given to its initializer
 // function.
-type Log struct{ output io.Writer }
+type Log struct {
+	output io.Writer
+	opts   Options
+	muted  bool // current line is suppressed
+	tinted bool // current line is colorized, requiring reset at Break
+}
+
+// Options contains the settings that control which lines are written and how
+// they are formatted.
+type Options struct {
+	Quiet     bool // suppress Info-level lines
+	Timestamp bool // prefix each line with its RFC3339 timestamp
+	Color     bool // colorize each line with ANSI escape sequences
+}
+
+// colorReset is the ANSI SGR escape sequence restoring default attributes.
+const colorReset = "\x1b[0m"
+
+// IsTerminal returns true if and only if the given io.Writer is an *os.File
+// referring to a character device (i.e., a terminal). All other io.Writer
+// implementations, including pipes and regular files, return false.
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if nil != err {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
 
 // New initializes and returns a pointer to a new Log.
 func New(output io.Writer) *Log {
-	return &Log{output: output}
+	return NewWithOptions(output, Options{})
+}
+
+// NewQuiet initializes and returns a pointer to a new Log that suppresses all
+// Info-level lines, including any content appended to them, and writes only
+// Warn- and Error-level lines.
+func NewQuiet(output io.Writer) *Log {
+	return NewWithOptions(output, Options{Quiet: true})
+}
+
+// NewWithOptions initializes and returns a pointer to a new Log with the given
+// options.
+func NewWithOptions(output io.Writer, opts Options) *Log {
+	return &Log{output: output, opts: opts}
+}
+
+// Redirect returns a pointer to a new Log with the same options as the
+// receiver, but which writes all messages to the given io.Writer.
+func (l *Log) Redirect(output io.Writer) *Log {
+	return NewWithOptions(output, l.opts)
+}
+
+// Quiet returns true if and only if the receiver suppresses Info-level lines.
+func (l *Log) Quiet() bool {
+	return l.opts.Quiet
 }
 
 // Break writes a single newline sequence to the receiver's io.Writer based on
 // the current host system (i.e., Unix: LF/0xA, Windows: CR+LF/0xD+0xA).
+// If the current line is suppressed, nothing is written, and the next line is
+// no longer suppressed.
 func (l *Log) Break() {
+	if l.muted {
+		l.muted = false
+		return
+	}
+	if l.tinted {
+		l.tinted = false
+		fmt.Fprint(l.output, colorReset)
+	}
 	fmt.Fprint(l.output, Eol)
 }
 
 // Putf prints to the receiver's io.Writer a string described by the given
 // format string and list of arguments.
 // No decorators or line-endings are placed anywhere around this string; it is
-// printed to the stream verbatim.
+// printed to the stream verbatim (unless the current line is suppressed).
 func (l *Log) Putf(format string, args ...interface{}) {
+	if l.muted {
+		return
+	}
 	fmt.Fprintf(l.output, format, args...)
 }
 
@@ -41,8 +111,34 @@ func (l *Log) Putf(format string, args ...interface{}) {
 //
 // For example, the following output can be recreated using this design:
 //    "   [download] host/url -> myPath ..." (** 60s elapses **) "ok!\n"
+//
+// If the receiver is quiet and level is Info, the line is suppressed until the
+// next call to Break.
+// If the receiver has timestamps enabled, the line is prefixed with the current
+// time in RFC3339 format, preceding the level symbol.
+// If the receiver has color enabled, the level symbol and class are colorized
+// according to level, and Warn and Error lines are colorized until the next
+// Break.
 func (l *Log) Writef(level Level, class string, format string, args ...interface{}) {
-	fmt.Fprintf(l.output, " %c [%s] ", level.Symbol(), class)
+	l.muted = l.opts.Quiet && level == Info
+	if l.muted {
+		return
+	}
+	if l.opts.Timestamp {
+		fmt.Fprint(l.output, time.Now().Format(time.RFC3339))
+	}
+	if l.opts.Color {
+		// Warn and Error lines are colorized entirely, Info lines only up to the
+		// message.
+		l.tinted = level != Info
+		fmt.Fprintf(l.output, " %s%c [%s]", level.Color(), level.Symbol(), class)
+		if !l.tinted {
+			fmt.Fprint(l.output, colorReset)
+		}
+		fmt.Fprint(l.output, " ")
+	} else {
+		fmt.Fprintf(l.output, " %c [%s] ", level.Symbol(), class)
+	}
 	l.Putf(format, args...)
 }
 
@@ -52,6 +148,12 @@ func (l *Log) Infof(class string, format string, args ...interface{}) {
 	l.Writef(Info, class, format, args...)
 }
 
+// Warnf calls Writef by automatically using Warn for level.
+// All other arguments are passed through to Writef as-is.
+func (l *Log) Warnf(class string, format string, args ...interface{}) {
+	l.Writef(Warn, class, format, args...)
+}
+
 // Errorf calls Writef by automatically using Error for level.
 // All other arguments are passed through to Writef as-is.
 func (l *Log) Errorf(class string, format string, args ...interface{}) {
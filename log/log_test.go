@@ -0,0 +1,37 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestQuietSuccess(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewWithOptions(&buf, Options{Quiet: true})
+	l.Infof("test", "working ...")
+	l.Eolf("test", nil, " (ok)")
+	l.Infof("test", "working again ...")
+	l.Break()
+	if buf.Len() > 0 {
+		t.Errorf("quiet log wrote %q on success, want nothing", buf.String())
+	}
+}
+
+func TestQuietError(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewWithOptions(&buf, Options{Quiet: true})
+	l.Infof("test", "working ...")
+	l.Eolf("test", errors.New("it failed"), " (ok)")
+	got := buf.String()
+	if !strings.Contains(got, "[test] it failed") {
+		t.Errorf("quiet log wrote %q on error, want the error", got)
+	}
+	if strings.Contains(got, "working") || strings.Contains(got, "(ok)") {
+		t.Errorf("quiet log wrote %q on error, want only the error", got)
+	}
+	if !strings.HasSuffix(got, Eol) {
+		t.Errorf("quiet log wrote %q on error, want a terminated line", got)
+	}
+}
@@ -0,0 +1,41 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// lineWriter is an io.Writer that writes each complete line of its input as a
+// separate Info-level message of a given class.
+type lineWriter struct {
+	log   *Log
+	class string
+	buf   []byte // incomplete line pending a newline
+}
+
+// Writer returns an io.Writer that writes each line of its input as a separate
+// Info-level message of the given class, such as for relaying the progress of
+// an external command. Blank lines are discarded.
+func (l *Log) Writer(class string) io.Writer {
+	return &lineWriter{log: l, class: class}
+}
+
+// Write implements io.Writer, logging each complete line of p. Any trailing
+// content not terminated by a newline is retained until the next call.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+		if strings.TrimSpace(line) != "" {
+			w.log.Infof(w.class, "%s", line)
+			w.log.Break()
+		}
+	}
+	return len(p), nil
+}
@@ -1,35 +1,31 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/ardnew/svngrab/config"
 	"github.com/ardnew/svngrab/log"
 	"github.com/ardnew/svngrab/repo"
 	"github.com/ardnew/svngrab/run"
-)
-
-var (
-	PROJECT   string
-	IMPORT    string
-	VERSION   string
-	BUILDTIME string
-	PLATFORM  string
-	BRANCH    string
-	REVISION  string
+	"github.com/ardnew/svngrab/version"
 )
 
 const umaskExport = 0022 // octal file mode (----w--w-)
 
+const configEnvVar = "SVNGRAB_CONFIG" // default configuration file path
+
 func usage(set *flag.FlagSet, separated, detailed bool) {
 	exe := filepath.Base(executablePath())
-	ver := fmt.Sprintf("%s %s %s %s@%s %s",
-		IMPORT, VERSION, PLATFORM, BRANCH, REVISION, BUILDTIME)
+	ver := version.String()
 	if separated {
 		fmt.Fprintln(os.Stderr, "--")
 	}
@@ -50,32 +46,208 @@ func usage(set *flag.FlagSet, separated, detailed bool) {
 		fmt.Fprintln(os.Stderr, "  enclosed with quotes, such as \"VAR=V A L\".")
 		fmt.Fprintln(os.Stderr)
 		fmt.Fprintln(os.Stderr, "  With the variable definition VAR=VAL, the variable may be referenced in the")
-		fmt.Fprintln(os.Stderr, "  configuration file as $VAR. A simple single-pass string substitution is")
-		fmt.Fprintln(os.Stderr, "  performed to replace all occurrences of $VAR with VAL.")
+		fmt.Fprintln(os.Stderr, "  configuration file as $VAR. All occurrences of $VAR are replaced with VAL.")
+		fmt.Fprintln(os.Stderr, "  VAL may itself reference other variables (e.g., \"OUT=dist/$TAG\"), which")
+		fmt.Fprintln(os.Stderr, "  are substituted in turn; a variable that references itself, directly or")
+		fmt.Fprintln(os.Stderr, "  through other variables, is an error.")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "  If $VAR is not defined on the command-line or as a builtin, the value of")
+		fmt.Fprintln(os.Stderr, "  environment variable VAR is used instead. If VAR is not defined in the")
+		fmt.Fprintln(os.Stderr, "  environment either, $VAR is left as-is (or an error with -strict-vars).")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "  The shell-style forms ${VAR}, ${VAR:-default}, and ${VAR:+alternate} are")
+		fmt.Fprintln(os.Stderr, "  also supported. Use \\$ to write a literal $ that is never substituted.")
 		fmt.Fprintln(os.Stderr)
 		fmt.Fprintln(os.Stderr, "  The following builtin variables are always available, but may be overridden")
 		fmt.Fprintln(os.Stderr, "  with definitions provided as command-line arguments:")
+		fmt.Fprintln(os.Stderr, "  	$DATE       # current local date (\"YYYYMMDD\")")
 		fmt.Fprintln(os.Stderr, "  	$DATETIME   # current local date-time (\"YYYYMMDD-hhmmss\")")
+		fmt.Fprintln(os.Stderr, "  	$CWD        # current working directory (after -C)")
+		fmt.Fprintln(os.Stderr, "  	$USER       # name of the current user")
+		fmt.Fprintln(os.Stderr, "  	$HOST       # host name reported by the system")
+		fmt.Fprintln(os.Stderr, "  If $CWD, $USER, or $HOST cannot be determined, it is treated as undefined.")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "  The package section (paths, include and copy fields, hooks, and compress")
+		fmt.Fprintln(os.Stderr, "  output) may also reference the revisions of each exported repository <name>,")
+		fmt.Fprintln(os.Stderr, "  which are known only after all repositories have been exported, and which")
+		fmt.Fprintln(os.Stderr, "  override command-line definitions of the same name:")
+		fmt.Fprintln(os.Stderr, "  	$REPO_<name>_PREVREV   # revision before this run (\"\" if first run)")
+		fmt.Fprintln(os.Stderr, "  	$REPO_<name>_CURRREV   # revision exported by this run")
+		fmt.Fprintln(os.Stderr, "  For example, copy to package: \"./rev-${REPO_<name>_CURRREV}/\".")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "configuration file:")
+		fmt.Fprintln(os.Stderr, "  The configuration file path is the first of the following defined:")
+		fmt.Fprintln(os.Stderr, "  	-f path                  # command-line option")
+		fmt.Fprintln(os.Stderr, "  	$"+configEnvVar+"          # environment variable")
+		fmt.Fprintln(os.Stderr, "  	<executable name>.yml    # in the current working directory (after -C)")
+		fmt.Fprintln(os.Stderr, "  Relative paths are relative to the current working directory (after -C).")
+		fmt.Fprintln(os.Stderr, "  If the path is a directory, each *.yml, *.yaml, and *.json file in it")
+		fmt.Fprintln(os.Stderr, "  (except those included by another) is merged; a repository or package")
+		fmt.Fprintln(os.Stderr, "  defined in more than one file is an error. Revisions are written back to")
+		fmt.Fprintln(os.Stderr, "  the file defining each repository.")
+		fmt.Fprintln(os.Stderr, "  The top-level svn field selects the svn executable (path) and the options")
+		fmt.Fprintln(os.Stderr, "  passed to every svn command (args), e.g., [\"--config-dir\", \"dir\"]. These")
+		fmt.Fprintln(os.Stderr, "  precede the --non-interactive, --username, and --password options added for")
+		fmt.Fprintln(os.Stderr, "  repositories with credentials, which must not be repeated in args; add")
+		fmt.Fprintln(os.Stderr, "  --non-interactive to args to never prompt for credentials at all.")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "exit status:")
+		fmt.Fprintln(os.Stderr, "  	0     success")
+		fmt.Fprintln(os.Stderr, "  	1     invalid command-line option value")
+		fmt.Fprintln(os.Stderr, "  	2     all working copies up-to-date (-u, -s), or unrecognized option")
+		fmt.Fprintln(os.Stderr, "  	3     one or more operations failed (-k)")
+		fmt.Fprintln(os.Stderr, "  	4     required repository not updated (-require-update)")
+		fmt.Fprintln(os.Stderr, "  	10-16 configuration error (directory, file, path, validation, include cycle)")
+		fmt.Fprintln(os.Stderr, "  	17    invalid compress method")
+		fmt.Fprintln(os.Stderr, "  	18    invalid compress level")
+		fmt.Fprintln(os.Stderr, "  	19    invalid shell dialect")
+		fmt.Fprintln(os.Stderr, "  	20-24 repository error (invalid, connection, export, revision)")
+		fmt.Fprintln(os.Stderr, "  	100   invalid ignore pattern")
+		fmt.Fprintln(os.Stderr, "  	101   invalid checksum algorithm")
+		fmt.Fprintln(os.Stderr, "  	102   invalid copy condition")
+		fmt.Fprintln(os.Stderr, "  	103   hook command failed")
+		fmt.Fprintln(os.Stderr, "  	104   mirror failed")
+		fmt.Fprintln(os.Stderr, "  	105   already running (-lock-timeout)")
+		fmt.Fprintln(os.Stderr, "  	106   unsafe copy destination (-allow-absolute-dest)")
+		fmt.Fprintln(os.Stderr, "  	107   incomplete archive (continueOnError)")
+		fmt.Fprintln(os.Stderr, "  	108   undefined variable (-strict-vars) or variable reference cycle")
+		fmt.Fprintln(os.Stderr, "  	109   unknown or excluded repository or package (-only, -except)")
+		fmt.Fprintln(os.Stderr, "  	130   interrupted (SIGINT, SIGTERM), revisions of completed exports recorded")
+		fmt.Fprintln(os.Stderr, "  	99    any other error")
 		fmt.Fprintln(os.Stderr)
 	}
 }
 
 func main() {
 
-	var configFilePath string // -f path
-	var helpFlag bool         // -h
-	var quietFlag bool        // -q
-	var updateFlag bool       // -u
-	var exportEnvPath string  // -x path
+	var allowAbsDest bool         // -allow-absolute-dest
+	var changeDir string          // -C dir
+	var checkFlag bool            // -check
+	var colorMode string          // -color when
+	var derefFlag bool            // -deref
+	var diffPath string           // -diff path
+	var errorFormat string        // -error-format format
+	var exceptNames string        // -except name[,name...]
+	var configFilePath string     // -f path
+	var flushEnvFlag bool         // -flush-env
+	var forceFlag bool            // -force
+	var forceCheckout string      // -force-checkout when
+	var helpFlag bool             // -h
+	var initFlag bool             // -init
+	var jobsCount int             // -j N
+	var keepGoingFlag bool        // -k
+	var lockTimeout time.Duration // -lock-timeout d
+	var limitRate string          // -limit rate
+	var listVarsFlag bool         // -list-vars
+	var logFilePath string        // -log path
+	var outputPath string         // -o path
+	var parentsFlag bool          // -p
+	var summaryPath string        // -J path
+	var printConfigFlag bool      // -print-config
+	var quietFlag bool            // -q
+	var retryCount int            // -r N
+	var showSecretsFlag bool      // -show-secrets
+	var requireUpdate string      // -require-update name[,name...]
+	var shellDialect string       // -shell name
+	var sinceLastFlag bool        // -since-last
+	var statusFlag bool           // -s
+	var onlyNames string          // -only name[,name...]
+	var updateFlag bool           // -u
+	var uniqueEnvFlag bool        // -unique-env
+	var strictVarsFlag bool       // -strict-vars
+	var svnPath string            // -svn path
+	var svnArgs string            // -svn-args args
+	var timestampFlag bool        // -t
+	var timeout time.Duration     // -timeout d
+	var verboseFlag bool          // -v
+	var versionFlag bool          // -V
+	var noWriteFlag bool          // -W
+	var exportEnvPath string      // -x path
 
+	flag.BoolVar(&allowAbsDest, "allow-absolute-dest", false,
+		"allow copy operations to absolute package paths, which may be outside of the package (code 106)")
+	flag.StringVar(&changeDir, "C", "",
+		"[C]hange to directory `dir` before doing anything else")
+	flag.BoolVar(&checkFlag, "check", false,
+		"check that every repository is reachable and every archive method valid, and exit (code 3 if not)")
+	flag.StringVar(&colorMode, "color", "auto",
+		"colorize log output `when`: always, never, or auto (if terminal)")
+	flag.BoolVar(&derefFlag, "deref", false,
+		"archive the files referred to by symbolic links instead of the links (required to archive symlink copies)")
+	flag.StringVar(&diffPath, "diff", "",
+		"write the paths changed in each repository since its last export as TSV at `path` (or \"-\" stdout)")
+	flag.StringVar(&errorFormat, "error-format", "text",
+		"report failure to stderr in `format` text (log only) or json ({\"code\",\"type\",\"message\",\"output\"})")
+	flag.StringVar(&exceptNames, "except", "",
+		"process all repositories and packages except those in comma-separated `names` (code 109)")
 	flag.StringVar(&configFilePath, "f", filepath.Base(defaultConfigFilePath()),
-		"use configuration [f]ile at `path`")
+		"use configuration [f]ile at `path` (or \"-\" stdin, or a directory of files), overriding $"+configEnvVar)
+	flag.BoolVar(&flushEnvFlag, "flush-env", false,
+		"write each section of the -x script as soon as it is complete, leaving a partial script on failure")
+	flag.BoolVar(&forceFlag, "force", false,
+		"build every package, even those skipped by -since-last")
+	flag.StringVar(&forceCheckout, "force-checkout", "",
+		"discard working copies and check out anew `when`: always, or failed (if the update fails)")
 	flag.BoolVar(&helpFlag, "h", false,
 		"show the extended [h]elp cruft")
+	flag.BoolVar(&initFlag, "init", false,
+		"write an example configuration file (see -f) and exit")
+	flag.IntVar(&jobsCount, "j", 1,
+		"export repositories and build packages up to `N` at a time ([j]obs)")
+	flag.StringVar(&summaryPath, "J", "",
+		"write a [J]SON summary of the run at `path` (or \"-\" stdout, logging to stderr)")
+	flag.BoolVar(&keepGoingFlag, "k", false,
+		"[k]eep going after failed copy/archive operations, exit non-zero at end (code 3)")
+	flag.DurationVar(&lockTimeout, "lock-timeout", 0,
+		"wait up to duration `d` for another run of the same configuration to finish (code 105)")
+	flag.StringVar(&limitRate, "limit", "",
+		"limit exports to an average `rate` of bytes per second (e.g., 2MB), one at a time")
+	flag.BoolVar(&listVarsFlag, "list-vars", false,
+		"print each variable defined by builtins and arguments with its value, and exit (see -show-secrets)")
+	flag.StringVar(&logFilePath, "log", "",
+		"also write log output to file at `path`")
+	flag.StringVar(&onlyNames, "only", "",
+		"process only the repositories and packages (and their repositories) in comma-separated `names` (code 109)")
+	flag.StringVar(&outputPath, "o", "",
+		"write updated configuration to [o]utput `path` instead of -f path\n(revisions are not recorded with \"-f -\" unless given)")
+	flag.BoolVar(&parentsFlag, "p", false,
+		"create missing [p]arent directories of the configuration written by -init or -o")
+	flag.BoolVar(&printConfigFlag, "print-config", false,
+		"print the configuration as YAML with all variables substituted, and exit without exporting")
 	flag.BoolVar(&quietFlag, "q", false,
 		"[q]uiet, output as little as possible")
+	flag.IntVar(&retryCount, "r", 0,
+		"[r]etry failed network operations up to `N` times with exponential backoff")
+	flag.StringVar(&requireUpdate, "require-update", "",
+		"fail (code 4) without packaging if any repository in comma-separated `names` is not updated")
+	flag.BoolVar(&statusFlag, "s", false,
+		"print the [s]tatus of each repository without exporting (code 2 if all up-to-date)")
+	flag.BoolVar(&showSecretsFlag, "show-secrets", false,
+		"print passwords and secret variable values (e.g., *PASS*, *TOKEN*) with -print-config and -list-vars")
+	flag.StringVar(&shellDialect, "shell", "",
+		"write the -x environment script in shell dialect `name` (sh, fish, powershell, cmd, dotenv, github)\n(default \"sh\", \"dotenv\" if -x path ends with \".env\", or \"github\" if -x path is $GITHUB_OUTPUT)")
+	flag.BoolVar(&sinceLastFlag, "since-last", false,
+		"build only packages including a repository updated since its last export (or no repository)")
+	flag.BoolVar(&strictVarsFlag, "strict-vars", false,
+		"fail if a variable is not defined by arguments, builtins, or environment")
+	flag.StringVar(&svnPath, "svn", "",
+		"run the svn executable at `path` instead of the configured one (default \"svn\" in PATH)")
+	flag.StringVar(&svnArgs, "svn-args", "",
+		"pass space-separated `args` to every svn command, after the configured args and before credentials")
+	flag.BoolVar(&timestampFlag, "t", false,
+		"prefix each log line with an RFC3339 [t]imestamp")
+	flag.DurationVar(&timeout, "timeout", 0,
+		"fail network operations (with all retries) not completed within duration `d` (e.g., 90s, 5m)")
 	flag.BoolVar(&updateFlag, "u", false,
 		"if all working copies are [u]p-to-date, exit immediately (code 2)")
+	flag.BoolVar(&uniqueEnvFlag, "unique-env", false,
+		"append a numeric suffix to -x variable names that collide once sanitized, instead of overwriting")
+	flag.BoolVar(&verboseFlag, "v", false,
+		"[v]erbose, log the progress of each SVN checkout/update")
+	flag.BoolVar(&versionFlag, "V", false,
+		"print [V]ersion information and exit")
+	flag.BoolVar(&noWriteFlag, "W", false,
+		"do not [W]rite updated repository revisions to the configuration (see -o)")
 	flag.StringVar(&exportEnvPath, "x", "",
 		"e[x]port results as shell environment script at `path` (or \"-\" stdout, \"+\" stderr)")
 	flag.Usage = func() { usage(flag.CommandLine, false, false) }
@@ -86,6 +258,21 @@ func main() {
 		os.Exit(0)
 	}
 
+	// always print version info, regardless of -q, since that's the whole point.
+	if versionFlag {
+		fmt.Println(version.String())
+		os.Exit(0)
+	}
+
+	// change directory before resolving any paths, so that all relative paths
+	// (including the default configuration file) are relative to dir.
+	if changeDir != "" {
+		if err := os.Chdir(changeDir); nil != err {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+	}
+
 	flags := flagsProvided(flag.CommandLine)
 
 	_, configFileProvided := flags["f"]
@@ -102,46 +289,234 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the shell dialect is implied by the -x path (e.g., ".env" files) unless
+	// given explicitly.
+	dialect := run.DialectOf(exportEnvPath)
+	var err error
+	if _, ok := flags["shell"]; ok {
+		dialect, err = run.ParseDialect(shellDialect)
+	}
+	if nil != err {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		usage(flag.CommandLine, true, false)
+		os.Exit(1)
+	}
+
+	switch errorFormat {
+	case "text", "json":
+	default:
+		fmt.Fprintln(os.Stderr, "error:", "invalid error format:", errorFormat)
+		usage(flag.CommandLine, true, false)
+		os.Exit(1)
+	}
+
+	var force run.ForceCheckout
+	switch forceCheckout {
+	case "":
+	case "always":
+		force = run.AlwaysForceCheckout
+	case "failed":
+		force = run.ForceCheckoutOnFailure
+	default:
+		fmt.Fprintln(os.Stderr, "error:", "invalid force checkout mode:", forceCheckout)
+		usage(flag.CommandLine, true, false)
+		os.Exit(1)
+	}
+
+	limit, err := config.ParseSize(limitRate)
+	if nil != err {
+		fmt.Fprintln(os.Stderr, "error:", "invalid rate limit:", limitRate)
+		usage(flag.CommandLine, true, false)
+		os.Exit(1)
+	}
+
 	vars, _ := userVariables(flag.Args()...)
 
-	switch err := run.Run(log.New(os.Stdout),
-		configFilePath, makeShellEnv(exportEnvPath), updateFlag, vars).(type) {
-	case config.DirectoryNotFoundError:
-		os.Exit(10)
-	case config.ConfigFileNotFoundError:
-		if !configFileProvided {
-			usage(flag.CommandLine, true, false)
+	var lw io.Writer = os.Stdout
+	if summaryPath == "-" || diffPath == "-" || printConfigFlag || listVarsFlag || quietFlag {
+		lw = os.Stderr
+	}
+	if logFilePath != "" {
+		// writes to the file are unbuffered, so it is simply closed on exit.
+		lw = io.MultiWriter(lw, makeLogFile(logFilePath))
+	}
+	var colorFlag bool
+	switch strings.ToLower(colorMode) {
+	case "always":
+		colorFlag = true
+	case "never":
+		colorFlag = false
+	case "auto":
+		colorFlag = log.IsTerminal(lw)
+	default:
+		fmt.Fprintln(os.Stderr, "error:", "invalid color mode:", colorMode)
+		usage(flag.CommandLine, true, false)
+		os.Exit(1)
+	}
+	lg := log.NewWithOptions(lw, log.Options{
+		Quiet:     quietFlag,
+		Timestamp: timestampFlag,
+		Color:     colorFlag,
+	})
+
+	if initFlag {
+		lg.Infof("init", "writing example configuration file: %s ...", configFilePath)
+		if parentsFlag {
+			err = config.MakeDir(configFilePath)
 		}
-		os.Exit(11)
-	case config.InvalidPathError:
-		if !configFileProvided {
-			usage(flag.CommandLine, true, false)
+		if nil == err {
+			err = config.Scaffold(configFilePath)
 		}
-		os.Exit(12)
-	case config.NotRegularFileError:
+		lg.Eolf("init", err, " (ok)")
+	} else {
+		opt := run.Options{
+			Log:               lg,
+			ConfigPath:        configFilePath,
+			OutputPath:        outputPath,
+			MakeParents:       parentsFlag,
+			NoWrite:           noWriteFlag,
+			Variables:         vars,
+			Update:            updateFlag,
+			RequireUpdate:     splitList(requireUpdate),
+			Only:              splitList(onlyNames),
+			Except:            splitList(exceptNames),
+			StrictVars:        strictVarsFlag,
+			SinceLast:         sinceLastFlag,
+			Force:             forceFlag,
+			ChangedPaths:      diffPath != "",
+			KeepGoing:         keepGoingFlag,
+			AllowAbsoluteDest: allowAbsDest,
+			Dereference:       derefFlag,
+			ForceCheckout:     force,
+			Verbose:           verboseFlag,
+			Jobs:              jobsCount,
+			Retries:           retryCount,
+			Limit:             limit,
+			Timeout:           timeout,
+			LockTimeout:       lockTimeout,
+			ShowSecrets:       showSecretsFlag,
+			Svn:               config.SvnConfig{Path: svnPath, Args: strings.Fields(svnArgs)},
+		}
+		if listVarsFlag {
+			err = run.ListVariables(opt, os.Stdout)
+		} else if printConfigFlag {
+			err = run.PrintConfig(opt, os.Stdout)
+		} else if checkFlag {
+			err = run.Check(opt, os.Stdout)
+		} else if statusFlag {
+			err = run.Status(opt, os.Stdout)
+		} else {
+			opt.Env = makeShellEnv(exportEnvPath, dialect)
+			opt.Env.Unique = uniqueEnvFlag
+			opt.Env.Incremental = flushEnvFlag
+			opt.Interrupt = interruptOnSignal()
+			var res run.Result
+			res, err = run.Execute(opt)
+			writeSummary(summaryPath, res)
+			writeChanges(diffPath, res)
+		}
+	}
+
+	code, kind := exitStatus(err)
+	switch err.(type) {
+	case config.ConfigFileNotFoundError, config.InvalidPathError, config.NotRegularFileError:
 		if !configFileProvided {
 			usage(flag.CommandLine, true, false)
 		}
-		os.Exit(13)
+	}
+	if nil != err && errorFormat == "json" {
+		writeErrorJSON(os.Stderr, code, kind, err)
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+func exitStatus(err error) (code int, kind string) {
+	kind = "Error"
+	if e, ok := err.(interface{ Type() string }); ok {
+		kind = e.Type()
+	}
+	switch err.(type) {
+	case nil:
+		return 0, ""
+	case config.DirectoryNotFoundError:
+		return 10, kind
+	case config.ConfigFileNotFoundError:
+		return 11, kind
+	case config.InvalidPathError:
+		return 12, kind
+	case config.NotRegularFileError:
+		return 13, kind
 	case config.FileExistsError:
-		os.Exit(14)
+		return 14, kind
+	case config.ValidationError:
+		return 15, kind
+	case config.IncludeCycleError:
+		return 16, kind
+	case run.InvalidCompressMethod:
+		return 17, kind
+	case run.InvalidCompressLevel:
+		return 18, kind
+	case run.InvalidShellDialect:
+		return 19, kind
 	case repo.InvalidRepositoryError:
-		os.Exit(20)
+		return 20, kind
 	case repo.ConnectionFailedError:
-		os.Exit(21)
+		return 21, kind
 	case repo.ExportFailedError:
-		os.Exit(22)
+		return 22, kind
 	case repo.UnknownRevisionError:
-		os.Exit(23)
+		return 23, kind
+	case repo.InvalidRevisionError:
+		return 24, kind
 	case run.InvalidIgnorePattern:
-		os.Exit(100)
+		return 100, kind
+	case run.InvalidChecksumAlgo:
+		return 101, kind
+	case run.InvalidCondition:
+		return 102, kind
+	case run.HookFailedError:
+		return 103, kind
+	case run.MirrorFailedError:
+		return 104, kind
+	case run.AlreadyRunningError:
+		return 105, kind
+	case run.UnsafeDestinationError:
+		return 106, kind
+	case run.IncompleteArchiveError:
+		return 107, kind
+	case run.VariableError:
+		return 108, kind
+	case run.InvalidSelectorError:
+		return 109, kind
+	case run.InterruptedError:
+		return 130, kind
 	case run.WorkingCopiesUpToDate:
-		os.Exit(2)
+		return 2, kind
+	case run.MultiError:
+		return 3, kind
+	case run.RepositoryUnchanged:
+		return 4, kind
 	default:
-		if nil != err {
-			os.Exit(99)
-		}
+		return 99, kind
+	}
+}
+
+func writeErrorJSON(w io.Writer, code int, kind string, err error) {
+	var output string
+	switch e := err.(type) {
+	case repo.ConnectionFailedError:
+		output = e.Output
+	case repo.ExportFailedError:
+		output = e.Output
 	}
+	_ = json.NewEncoder(w).Encode(struct {
+		Code    int    `json:"code"`
+		Type    string `json:"type"`
+		Message string `json:"message"`
+		Output  string `json:"output,omitempty"`
+	}{code, kind, err.Error(), output})
 }
 
 func executablePath() string {
@@ -157,6 +532,12 @@ func defaultConfigFilePath() string {
 	if nil != err {
 		panic("error: cannot determine working directory: " + err.Error())
 	}
+	if path := os.Getenv(configEnvVar); path != "" {
+		if path == config.Stdin || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
 	name := filepath.Base(executablePath())
 	if ext := filepath.Ext(name); "" != ext {
 		name = strings.TrimSuffix(name, ext)
@@ -164,30 +545,117 @@ func defaultConfigFilePath() string {
 	return filepath.Join(dir, name+".yml")
 }
 
+func interruptOnSignal() <-chan struct{} {
+	// the first signal stops the run gracefully, and the second exits now.
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	interrupt := make(chan struct{})
+	go func() {
+		<-sig
+		close(interrupt)
+		<-sig
+		os.Exit(130)
+	}()
+	return interrupt
+}
+
 func flagsProvided(set *flag.FlagSet) map[string]flag.Value {
 	m := map[string]flag.Value{}
 	set.Visit(func(f *flag.Flag) { m[f.Name] = f.Value })
 	return m
 }
 
-func makeShellEnv(path string) *run.ShellEnv {
+func makeShellEnv(path string, dialect run.Dialect) *run.ShellEnv {
 	switch path {
 	case "":
-		return run.NewShellEnv("<bitbucket>", io.Discard, nil)
+		return run.NewShellEnv("<bitbucket>", dialect, io.Discard, nil)
 	case "-":
-		return run.NewShellEnv("<stdout>", os.Stdout, os.Stdout)
+		return run.NewShellEnv("<stdout>", dialect, os.Stdout, os.Stdout)
 	case "+":
-		return run.NewShellEnv("<stderr>", os.Stderr, os.Stderr)
+		return run.NewShellEnv("<stderr>", dialect, os.Stderr, os.Stderr)
 	default:
 		if err := os.MkdirAll(filepath.Dir(path), umaskExport); err != nil {
 			panic("error: invalid environment export path: " + err.Error())
 		}
-		rw, err := os.Create(path)
+		// GitHub Actions output files are shared by all steps of a job, so they
+		// are appended to rather than replaced.
+		mode := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		if dialect == run.GitHubDialect {
+			mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		rw, err := os.OpenFile(path, mode, 0666)
 		if err != nil {
 			panic("error: open environment export file for read/write: " + err.Error())
 		}
-		return run.NewShellEnv(path, rw, rw)
+		return run.NewShellEnv(path, dialect, rw, rw)
+	}
+}
+
+func makeLogFile(path string) *os.File {
+	if err := os.MkdirAll(filepath.Dir(path), umaskExport); err != nil {
+		panic("error: invalid log file path: " + err.Error())
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		panic("error: open log file for writing: " + err.Error())
+	}
+	return f
+}
+
+func writeSummary(path string, res run.Result) {
+	switch path {
+	case "":
+		return
+	case "-":
+		if err := res.WriteJSON(os.Stdout); err != nil {
+			panic("error: write JSON summary: " + err.Error())
+		}
+	default:
+		if err := os.MkdirAll(filepath.Dir(path), umaskExport); err != nil {
+			panic("error: invalid JSON summary path: " + err.Error())
+		}
+		w, err := os.Create(path)
+		if err != nil {
+			panic("error: open JSON summary file for writing: " + err.Error())
+		}
+		defer w.Close()
+		if err := res.WriteJSON(w); err != nil {
+			panic("error: write JSON summary: " + err.Error())
+		}
+	}
+}
+
+func writeChanges(path string, res run.Result) {
+	switch path {
+	case "":
+		return
+	case "-":
+		if err := res.WriteChanges(os.Stdout); err != nil {
+			panic("error: write changed paths: " + err.Error())
+		}
+	default:
+		if err := os.MkdirAll(filepath.Dir(path), umaskExport); err != nil {
+			panic("error: invalid changed paths path: " + err.Error())
+		}
+		w, err := os.Create(path)
+		if err != nil {
+			panic("error: open changed paths file for writing: " + err.Error())
+		}
+		defer w.Close()
+		if err := res.WriteChanges(w); err != nil {
+			panic("error: write changed paths: " + err.Error())
+		}
+	}
+}
+
+func splitList(list string) []string {
+	var elem []string
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elem = append(elem, e)
+		}
 	}
+	return elem
 }
 
 func userVariables(argv ...string) (vars map[string]string, args []string) {
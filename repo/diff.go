@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/vcs"
+)
+
+// ChangedPath describes a single path changed between two revisions of a
+// repository.
+type ChangedPath struct {
+	Action string `json:"action"` // "A" (added), "M" (modified), or "D" (deleted)
+	Path   string `json:"path"`   // slash-separated, relative to the repository path
+}
+
+// ChangedPaths returns the paths changed in the repository after revision
+// from, up to and including revision to. If from is empty (i.e., the first
+// export), every file in the local path is reported as added.
+// Returns an empty list if from and to are equal.
+func (r *Repo) ChangedPaths(from, to string) ([]ChangedPath, error) {
+	switch {
+	case from == to:
+		return []ChangedPath{}, nil
+	case from == "":
+		return r.localPaths()
+	case r.isSvn():
+		return r.svnChangedPaths(from, to)
+	case r.Vcs() == vcs.Git:
+		return r.gitChangedPaths(from, to)
+	}
+	return nil, UnknownRevisionError("diff unsupported for repository type: " +
+		string(r.Vcs()))
+}
+
+// localPaths returns every file in the local path, excluding VCS metadata
+// directories, as added paths.
+func (r *Repo) localPaths() ([]ChangedPath, error) {
+	root := r.LocalPath()
+	paths := []ChangedPath{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		if info.IsDir() {
+			switch info.Name() {
+			case ".svn", ".git", ".hg", ".bzr":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if nil != err {
+			return err
+		}
+		paths = append(paths, ChangedPath{Action: "A", Path: filepath.ToSlash(rel)})
+		return nil
+	})
+	if nil != err {
+		return nil, vcs.NewLocalError("Unable to list local paths", err, "")
+	}
+	return paths, nil
+}
+
+// svnChangedPaths returns the paths changed in the remote SVN repository path
+// in the given range.
+// The remote repository is compared, rather than the working copy, so that
+// paths can be listed in export mode, which has no working copy.
+func (r *Repo) svnChangedPaths(from, to string) ([]ChangedPath, error) {
+	type entry struct {
+		Item string `xml:"item,attr"`
+		Path string `xml:",chardata"`
+	}
+	type diff struct {
+		Paths []entry `xml:"paths>path"`
+	}
+	remote := r.svnRemote()
+	out, err := r.svnCommand("diff", "--summarize", "--xml",
+		"-r", from+":"+to, remote+"@"+to).CombinedOutput()
+	if nil != err {
+		return nil, vcs.NewRemoteError("Unable to retrieve changed paths", err, string(out))
+	}
+	d := &diff{}
+	if err := xml.Unmarshal(out, d); nil != err {
+		return nil, vcs.NewLocalError("Unable to retrieve changed paths", err, string(out))
+	}
+	paths := []ChangedPath{}
+	for _, p := range d.Paths {
+		action := "M" // modified content or properties
+		switch p.Item {
+		case "added":
+			action = "A"
+		case "deleted":
+			action = "D"
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p.Path, remote), "/")
+		if rel == "" {
+			continue // properties of the repository path itself
+		}
+		paths = append(paths, ChangedPath{Action: action, Path: rel})
+	}
+	return paths, nil
+}
+
+// gitChangedPaths returns the paths changed in a Git working copy in the given
+// range.
+func (r *Repo) gitChangedPaths(from, to string) ([]ChangedPath, error) {
+	out, err := r.RunFromDir("git", "diff", "--name-status", "--no-renames", "-z", from, to)
+	if nil != err {
+		return nil, vcs.NewLocalError("Unable to retrieve changed paths", err, string(out))
+	}
+	// with -z, the output is a NUL-separated sequence of status, path pairs.
+	field := strings.Split(strings.TrimRight(string(out), "\x00"), "\x00")
+	paths := []ChangedPath{}
+	for i := 0; i+1 < len(field); i += 2 {
+		action := "M" // modified content or type
+		switch field[i] {
+		case "A", "D":
+			action = field[i]
+		}
+		paths = append(paths, ChangedPath{Action: action, Path: field[i+1]})
+	}
+	return paths, nil
+}
@@ -0,0 +1,33 @@
+package repo
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/vcs"
+)
+
+// gitHash matches a full Git commit hash (SHA-1 or SHA-256).
+var gitHash = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// gitRemoteRevision returns the configured revision if it is a full commit
+// hash, otherwise the commit hash that the configured revision (or HEAD)
+// refers to in the remote repository.
+func (r *Repo) gitRemoteRevision() (string, error) {
+	rev := r.cfg.Revision
+	if rev == "" {
+		rev = "HEAD"
+	} else if gitHash.MatchString(rev) {
+		return rev, nil
+	}
+	out, err := r.command("git", "ls-remote", r.Remote(), rev).CombinedOutput()
+	if nil != err {
+		return "", UnknownRevisionError(
+			vcs.NewRemoteError("Unable to retrieve remote revision", err, string(out)).Error())
+	}
+	// each line of output is "<hash>\t<ref>"; the first match is used.
+	if field := strings.Fields(string(out)); len(field) > 0 {
+		return field[0], nil
+	}
+	return "", UnknownRevisionError(r.Remote() + "@" + rev)
+}
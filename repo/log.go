@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/vcs"
+)
+
+// Log returns the commits made to the repository after revision from, up to
+// and including revision to, ordered from oldest to newest.
+// Returns an empty list if from and to are equal.
+func (r *Repo) Log(from, to string) ([]*vcs.CommitInfo, error) {
+	if from == to {
+		return []*vcs.CommitInfo{}, nil
+	}
+	if r.isSvn() {
+		return r.svnLog(from, to)
+	}
+	if r.Vcs() == vcs.Git {
+		return r.gitLog(from, to)
+	}
+	return nil, UnknownRevisionError("log unsupported for repository type: " +
+		string(r.Vcs()))
+}
+
+// svnLog returns the commits of an SVN working copy in the given range.
+func (r *Repo) svnLog(from, to string) ([]*vcs.CommitInfo, error) {
+	type logentry struct {
+		Revision string `xml:"revision,attr"`
+		Author   string `xml:"author"`
+		Date     string `xml:"date"`
+		Msg      string `xml:"msg"`
+	}
+	type log struct {
+		Entries []logentry `xml:"logentry"`
+	}
+	out, err := r.svnCommandFromDir("log", "--xml", "-r", from+":"+to).CombinedOutput()
+	if nil != err {
+		return nil, vcs.NewRemoteError("Unable to retrieve log", err, string(out))
+	}
+	entries := &log{}
+	if err := xml.Unmarshal(out, entries); nil != err {
+		return nil, vcs.NewLocalError("Unable to retrieve log", err, string(out))
+	}
+	info := []*vcs.CommitInfo{}
+	for _, e := range entries.Entries {
+		// the range is inclusive, but the commit at from was already exported.
+		if e.Revision == from {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339Nano, e.Date)
+		info = append(info, &vcs.CommitInfo{
+			Commit:  e.Revision,
+			Author:  e.Author,
+			Date:    date,
+			Message: strings.TrimSpace(e.Msg),
+		})
+	}
+	return info, nil
+}
+
+// gitLog returns the commits of a Git working copy in the given range.
+func (r *Repo) gitLog(from, to string) ([]*vcs.CommitInfo, error) {
+	// fields are separated by NUL and records by RS, neither of which occur in
+	// commit messages.
+	out, err := r.RunFromDir("git", "log", "--reverse",
+		"--format=%H%x00%an%x00%aI%x00%B%x1e", from+".."+to)
+	if nil != err {
+		return nil, vcs.NewLocalError("Unable to retrieve log", err, string(out))
+	}
+	info := []*vcs.CommitInfo{}
+	for _, rec := range strings.Split(string(out), "\x1e") {
+		field := strings.SplitN(strings.TrimSpace(rec), "\x00", 4)
+		if len(field) < 4 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, field[2])
+		info = append(info, &vcs.CommitInfo{
+			Commit:  field[0],
+			Author:  field[1],
+			Date:    date,
+			Message: strings.TrimSpace(field[3]),
+		})
+	}
+	return info, nil
+}
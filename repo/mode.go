@@ -8,9 +8,10 @@ type ExportMode int
 const (
 	UpdateMode ExportMode = iota
 	CheckoutMode
+	CleanMode // export without VCS metadata
 )
 
 // String returns the string representation of the receiver ExportMode.
 func (m ExportMode) String() string {
-	return []string{"diff", "pull"}[m]
+	return []string{"diff", "pull", "xprt"}[m]
 }
@@ -1,6 +1,15 @@
 package repo
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/ardnew/svngrab/config"
 
 	"github.com/Masterminds/vcs"
@@ -9,24 +18,52 @@ import (
 // Type definitions for various errors raised by repo package.
 type (
 	InvalidRepositoryError string
-	ConnectionFailedError  string
-	ExportFailedError      string
 	UnknownRevisionError   string
+	InvalidRevisionError   string
 )
 
+// ConnectionFailedError is returned when the remote repository is not
+// accessible. Output contains the output of the failed VCS command, if any.
+type ConnectionFailedError struct {
+	Msg    string
+	Output string
+}
+
+// ExportFailedError is returned when the remote repository cannot be
+// retrieved. Output contains the output of the failed VCS command, if any.
+type ExportFailedError struct {
+	Msg    string
+	Output string
+}
+
 // Error returns the string representation of InvalidRepositoryError
 func (e InvalidRepositoryError) Error() string {
 	return "invalid repository: " + string(e)
 }
 
+// Type returns the type name of InvalidRepositoryError
+func (e InvalidRepositoryError) Type() string {
+	return "InvalidRepositoryError"
+}
+
 // Error returns the string representation of ConnectionFailedError
 func (e ConnectionFailedError) Error() string {
-	return "failed to connect to repository: " + string(e)
+	return "failed to connect to repository: " + e.Msg
+}
+
+// Type returns the type name of ConnectionFailedError
+func (e ConnectionFailedError) Type() string {
+	return "ConnectionFailedError"
 }
 
 // Error returns the string representation of ExportFailedError
 func (e ExportFailedError) Error() string {
-	return "failed to export repository: " + string(e)
+	return "failed to export repository: " + e.Msg
+}
+
+// Type returns the type name of ExportFailedError
+func (e ExportFailedError) Type() string {
+	return "ExportFailedError"
 }
 
 // Error returns the string representation of UnknownRevisionError
@@ -34,32 +71,116 @@ func (e UnknownRevisionError) Error() string {
 	return "cannot determine revision of repository: " + string(e)
 }
 
-// Repo contains a VCS repository object (SVN-only) combined with its options
+// Type returns the type name of UnknownRevisionError
+func (e UnknownRevisionError) Type() string {
+	return "UnknownRevisionError"
+}
+
+// Error returns the string representation of InvalidRevisionError
+func (e InvalidRevisionError) Error() string {
+	return "invalid revision identifier: " + string(e)
+}
+
+// Type returns the type name of InvalidRevisionError
+func (e InvalidRevisionError) Type() string {
+	return "InvalidRevisionError"
+}
+
+// Repo contains a VCS repository object (SVN or Git) combined with its options
 // parsed from the configuration file.
 type Repo struct {
-	*vcs.SvnRepo
-	cfg config.ExportConfig
+	vcs.Repo
+	cfg      config.ExportConfig
+	svn      config.SvnConfig // svn command run by SVN repositories
+	progress io.Writer        // receives output of svn commands, if non-nil
+	exported string           // revision of the last clean export
+	timeout  time.Duration    // maximum duration of network operations, if positive
+	mu       sync.Mutex       // guards ctx
+	ctx      context.Context  // context of the running network operation, if any
 }
 
 // New returns a pointer to a new Repo object using the given configuration.
+// The VCS implementation is selected by the configuration's Type field, or
+// detected from the remote URL if Type is empty, in which case
+// InvalidRepositoryError is returned if the detected type is neither SVN nor
+// Git.
+// SVN repositories run the svn command configured by svn. However, the type of
+// a repository can only be detected using the svn executable found in PATH, so
+// Type should be "svn" if svn.Path is an executable not found in PATH.
 // A nil Repo pointer and non-nil error is returned if the VCS object could not
 // be created from the configuration options.
-func New(cfg config.ExportConfig) (*Repo, error) {
-	svn, err := vcs.NewSvnRepo(cfg.Url(), cfg.Wc())
+func New(cfg config.ExportConfig, svn config.SvnConfig) (*Repo, error) {
+	if !cfg.RevisionValid() {
+		return nil, InvalidRevisionError(cfg.Revision)
+	}
+	var (
+		rep vcs.Repo
+		err error
+	)
+	typ := vcs.Type(strings.ToLower(cfg.Type))
+	if cfg.CleanExport() {
+		// clean exports are only supported by svn, so there is nothing to detect.
+		if typ != vcs.Svn && typ != vcs.NoVCS {
+			return nil, InvalidRepositoryError("export mode requires svn: " + cfg.Type)
+		}
+		typ = vcs.Svn
+	}
+	switch typ {
+	case vcs.Svn:
+		if svn.Path != "" {
+			rep, err = newSvnRepo(svn, cfg.Url(), cfg.Wc())
+		} else {
+			rep, err = vcs.NewSvnRepo(cfg.Url(), cfg.Wc())
+		}
+	case vcs.Git:
+		rep, err = vcs.NewGitRepo(cfg.Url(), cfg.Wc())
+	case vcs.NoVCS:
+		rep, err = vcs.NewRepo(cfg.Url(), cfg.Wc())
+		// the vcs library also detects types that are not supported (e.g., hg).
+		if nil == err && rep.Vcs() != vcs.Svn && rep.Vcs() != vcs.Git {
+			return nil, InvalidRepositoryError("unsupported type: " + string(rep.Vcs()))
+		}
+	default:
+		return nil, InvalidRepositoryError("unsupported type: " + cfg.Type)
+	}
 	if nil != err {
 		return nil, InvalidRepositoryError(err.Error())
 	}
+	if rep.Vcs() != vcs.Svn && (cfg.Depth != "" || len(cfg.Paths) > 0 || cfg.IgnoreExternals) {
+		return nil, InvalidRepositoryError("depth, paths, and ignoreExternals require svn: " + cfg.Url())
+	}
 	return &Repo{
-		SvnRepo: svn,
-		cfg:     cfg,
+		Repo: rep,
+		cfg:  cfg,
+		svn:  svn,
 	}, nil
 }
 
-// Connect verifies communication with the remote repository, or returns an
-// error if the connection fails.
-func (r *Repo) IsConnected() (bool, error) {
-	if !r.Ping() {
-		return false, ConnectionFailedError(r.Remote())
+// SetProgress sets the io.Writer to which the standard output of svn checkout
+// and update commands is copied while they run (e.g., "A path/..." lines).
+// If w is nil, command output is captured silently.
+func (r *Repo) SetProgress(w io.Writer) {
+	r.progress = w
+}
+
+// IsConnected verifies communication with the remote repository, or returns
+// an error if the connection fails.
+// Failed connections are retried up to the given number of times, calling wait
+// (if non-nil) before each retry.
+func (r *Repo) IsConnected(retries int, wait RetryFunc) (bool, error) {
+	var n int
+	err := r.deadline(func(ctx context.Context) (err error) {
+		n, err = retry(ctx, retries, wait, func() error {
+			return r.ping()
+		})
+		return err
+	})
+	if nil != err {
+		if e, ok := err.(ConnectionFailedError); ok {
+			e.Msg = attempted(e.Msg, n)
+			return false, e
+		}
+		return false, ConnectionFailedError{Msg: r.Remote() + ": " + err.Error()}
 	}
 	return true, nil
 }
@@ -68,28 +189,127 @@ func (r *Repo) IsConnected() (bool, error) {
 // to retrieve the remote repository.
 // If a local working copy exists, the method returned is equivalent to an
 // update; otherwise, working copy does not exist, the method is a checkout.
+// If the repository is configured for clean exports, the method is always an
+// export, replacing the entire local tree.
 func (r *Repo) Exporter() (ExportMode, func() error) {
+	if r.cfg.CleanExport() {
+		return CleanMode, r.export
+	}
 	if r.CheckLocal() {
 		return UpdateMode, r.Update
 	}
 	return CheckoutMode, r.Get
 }
 
+// Discard removes the local working copy, such that the next Export performs a
+// fresh checkout. Only the configured working copy path is removed, and never
+// if it is empty, a filesystem root, or the current working directory, in which
+// case InvalidRepositoryError is returned.
+func (r *Repo) Discard() error {
+	wc := r.cfg.Wc()
+	abs, err := filepath.Abs(wc)
+	if nil != err {
+		return err
+	}
+	cwd, err := os.Getwd()
+	if nil != err {
+		return err
+	}
+	if r.cfg.Local == "" || abs == filepath.Dir(abs) || abs == cwd {
+		return InvalidRepositoryError("refusing to remove working copy: " + wc)
+	}
+	return os.RemoveAll(abs)
+}
+
 // Export retrieves the remote repository by either update or checkout,
 // depending on if the local working copy exists or not.
-func (r *Repo) Export() error {
+// If a revision is configured, the working copy is retrieved at exactly that
+// revision.
+// Failed exports are retried up to the given number of times, calling wait
+// (if non-nil) before each retry.
+func (r *Repo) Export(retries int, wait RetryFunc) error {
 	_, fetch := r.Exporter()
-	if err := fetch(); nil != err {
-		return ExportFailedError(err.Error())
+	var n int
+	err := r.deadline(func(ctx context.Context) (err error) {
+		n, err = retry(ctx, retries, wait, fetch)
+		return err
+	})
+	if nil != err {
+		msg := err.Error()
+		if _, ok := err.(timeoutError); !ok {
+			msg = attempted(msg, n)
+		}
+		return ExportFailedError{Msg: msg, Output: commandOutput(err)}
 	}
 	return nil
 }
 
 // Revision returns the repository revision of the local working copy.
+// For clean exports, which have no working copy metadata, it is the revision
+// exported, or the configured revision of the remote repository if no export
+// has been performed.
 func (r *Repo) Revision() (string, error) {
-	vers, err := r.Version()
+	if r.cfg.CleanExport() {
+		if r.exported != "" {
+			return r.exported, nil
+		}
+		return r.svnRemoteRevision()
+	}
+	version := r.Version
+	if r.isSvn() {
+		version = r.svnVersion
+	}
+	vers, err := version()
 	if nil != err {
 		return "", UnknownRevisionError(err.Error())
 	}
 	return vers, nil
 }
+
+// RemoteRevision returns the revision of the remote repository that would be
+// retrieved by Export, without retrieving it: the configured revision if one
+// is configured, otherwise the latest revision (HEAD).
+// For SVN, the revision is the last changed revision of the configured path,
+// which is comparable to the revision of a working copy (see Revision).
+func (r *Repo) RemoteRevision() (string, error) {
+	var query func() (string, error)
+	switch {
+	case r.isSvn():
+		query = r.svnRemoteRevision
+	case r.Vcs() == vcs.Git:
+		query = r.gitRemoteRevision
+	default:
+		return "", UnknownRevisionError("remote revision unsupported for repository type: " +
+			string(r.Vcs()))
+	}
+	var rev string
+	err := r.deadline(func(context.Context) (err error) {
+		rev, err = query()
+		return err
+	})
+	if nil != err {
+		if _, ok := err.(UnknownRevisionError); !ok {
+			return "", UnknownRevisionError(r.Remote() + ": " + err.Error())
+		}
+		return "", err
+	}
+	return rev, nil
+}
+
+// commandOutput returns the output of the failed VCS command described by the
+// given error, or an empty string if it has none.
+func commandOutput(err error) string {
+	if e, ok := err.(interface{ Out() string }); ok {
+		return strings.TrimSpace(e.Out())
+	}
+	return ""
+}
+
+// attempted appends the number of attempts made to the given error message if
+// more than one attempt was made.
+func attempted(msg string, attempts int) string {
+	if attempts > 1 {
+		return fmt.Sprintf("%s (%d attempts)", msg, attempts)
+	}
+	return msg
+}
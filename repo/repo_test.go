@@ -0,0 +1,32 @@
+// +build !windows
+
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardnew/svngrab/config"
+)
+
+func TestNewDetectsUnsupportedType(t *testing.T) {
+	// a stub hg executable allows the vcs library to detect the type.
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "hg"), []byte("#!/bin/sh\nexit 0\n"), 0755); nil != err {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	cfg := config.ExportConfig{
+		Repo:  "https://example.com/project.hg",
+		Local: filepath.Join(t.TempDir(), "wc"),
+	}
+	_, err := New(cfg, config.SvnConfig{})
+	if e, ok := err.(InvalidRepositoryError); !ok || string(e) != "unsupported type: hg" {
+		t.Errorf("New(%q) = %v, want unsupported type: hg", cfg.Repo, err)
+	}
+}
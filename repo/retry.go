@@ -0,0 +1,38 @@
+package repo
+
+import (
+	"context"
+	"time"
+)
+
+// RetryDelay is the delay before the first retry of a failed operation.
+// The delay doubles with each subsequent retry (exponential backoff).
+var RetryDelay = time.Second
+
+// RetryFunc is called before each retry of a failed operation with the number
+// of the attempt that failed and the delay before the next attempt.
+type RetryFunc func(attempt int, delay time.Duration)
+
+// retry calls op until it succeeds, until it has been retried the given number
+// of times, or until ctx is done, sleeping with exponential backoff between
+// each attempt.
+// If wait is non-nil, it is called before sleeping.
+// Returns the total number of attempts made and the error from the last one.
+func retry(ctx context.Context, retries int, wait RetryFunc, op func() error) (int, error) {
+	delay := RetryDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if nil == err || attempt > retries || nil != ctx.Err() {
+			return attempt, err
+		}
+		if nil != wait {
+			wait(attempt, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempt, err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,377 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ardnew/svngrab/config"
+
+	"github.com/Masterminds/vcs"
+)
+
+// isSvn returns true if and only if the receiver's VCS implementation is SVN.
+func (r *Repo) isSvn() bool {
+	return r.Vcs() == vcs.Svn
+}
+
+// svnArgs returns the given svn subcommand and arguments with the receiver's
+// global options inserted after the subcommand: first the additional arguments
+// of the svn command configuration, followed by the authentication credentials.
+// The returned slice should never be logged, as it may contain a password.
+func (r *Repo) svnArgs(sub string, args ...string) []string {
+	a := append([]string{sub}, r.svn.Args...)
+	if r.cfg.Username != "" || r.cfg.Password != "" {
+		a = append(a, "--non-interactive")
+		if r.cfg.Username != "" {
+			a = append(a, "--username", r.cfg.Username)
+		}
+		if r.cfg.Password != "" {
+			a = append(a, "--password", r.cfg.Password)
+		}
+	}
+	return append(a, args...)
+}
+
+// svnCommand returns the command running the configured svn executable with
+// the given subcommand and arguments (see svnArgs).
+func (r *Repo) svnCommand(sub string, args ...string) *exec.Cmd {
+	return r.command(r.svn.Executable(), r.svnArgs(sub, args...)...)
+}
+
+// svnCommandFromDir returns the command running the configured svn executable
+// with the given subcommand and arguments (see svnArgs) from the local path.
+func (r *Repo) svnCommandFromDir(sub string, args ...string) *exec.Cmd {
+	cmd := r.command(r.svn.Executable(), r.svnArgs(sub, args...)...)
+	cmd.Dir = r.LocalPath()
+	return cmd
+}
+
+// svnRetrieveArgs returns the options common to every svn command that
+// retrieves content into the local path (checkout, update, and export), given
+// the receiver's configuration.
+func (r *Repo) svnRetrieveArgs() []string {
+	if r.cfg.IgnoreExternals {
+		return []string{"--ignore-externals"}
+	}
+	return []string{}
+}
+
+// svnRemote returns the receiver's remote URL, converting local filesystem
+// paths to file:// URLs as expected by svn.
+func (r *Repo) svnRemote() string {
+	remote := r.Remote()
+	if strings.HasPrefix(remote, "/") {
+		remote = "file://" + remote
+	} else if runtime.GOOS == "windows" && filepath.VolumeName(remote) != "" {
+		remote = "file:///" + remote
+	}
+	return remote
+}
+
+// updateVersion updates a non-SVN working copy to the configured revision, if
+// one is configured.
+func (r *Repo) updateVersion() error {
+	if r.cfg.Revision == "" {
+		return nil
+	}
+	return r.UpdateVersion(r.cfg.Revision)
+}
+
+// output runs the given command and returns its combined standard output and
+// standard error. If the receiver has a progress writer, standard output is
+// also copied to it while the command runs.
+func (r *Repo) output(cmd *exec.Cmd) ([]byte, error) {
+	if nil == r.progress {
+		return cmd.CombinedOutput()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdout, r.progress)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return append(stdout.Bytes(), stderr.Bytes()...), err
+}
+
+// Get performs an initial checkout of the remote repository into the local
+// working copy path, at the configured revision if one is configured.
+func (r *Repo) Get() error {
+	if !r.isSvn() {
+		if err := r.Repo.Get(); nil != err {
+			return err
+		}
+		return r.updateVersion()
+	}
+	remote := r.svnRemote()
+	args := r.svnRetrieveArgs()
+	if rev := r.cfg.Revision; rev != "" {
+		// use the revision as both operative and peg revision so that paths
+		// which no longer exist in HEAD can still be retrieved.
+		args = append(args, "-r", rev)
+		remote += "@" + rev
+	}
+	depth := r.cfg.Depth
+	if len(r.cfg.Paths) > 0 {
+		// sparse checkout: only the root directory itself, with each configured
+		// subtree added to it afterwards.
+		depth = "empty"
+	}
+	if depth != "" {
+		args = append(args, "--depth", depth)
+	}
+	args = append(args, remote, r.LocalPath())
+	out, err := r.output(r.svnCommand("checkout", args...))
+	if nil != err {
+		return vcs.NewRemoteError("Unable to get repository", err, string(out))
+	}
+	return r.updatePaths()
+}
+
+// Update performs an update of an existing local working copy, to the
+// configured revision if one is configured.
+func (r *Repo) Update() error {
+	if !r.isSvn() {
+		if err := r.Repo.Update(); nil != err {
+			return err
+		}
+		return r.updateVersion()
+	}
+	args := r.svnRetrieveArgs()
+	if rev := r.cfg.Revision; rev != "" {
+		args = append(args, "-r", rev)
+	}
+	out, err := r.output(r.svnCommandFromDir("update", args...))
+	if nil != err {
+		return vcs.NewRemoteError("Unable to update repository", err, string(out))
+	}
+	return r.updatePaths()
+}
+
+// updatePaths updates each of the configured sparse subtrees of an existing
+// local working copy to the configured depth (default infinity), creating any
+// intermediate directories as needed.
+// The depth of the working copy root is sticky, so it is retained by updates
+// and is not changed by this method.
+func (r *Repo) updatePaths() error {
+	if len(r.cfg.Paths) == 0 {
+		return nil
+	}
+	depth := r.cfg.Depth
+	if depth == "" {
+		depth = "infinity"
+	}
+	args := append(r.svnRetrieveArgs(), "--parents", "--set-depth", depth)
+	if rev := r.cfg.Revision; rev != "" {
+		args = append(args, "-r", rev)
+	}
+	for _, p := range r.cfg.Paths {
+		args = append(args, filepath.Join(r.LocalPath(), filepath.FromSlash(p)))
+	}
+	out, err := r.output(r.svnCommand("update", args...))
+	if nil != err {
+		return vcs.NewRemoteError("Unable to update repository paths", err, string(out))
+	}
+	return nil
+}
+
+// svnRemoteRevision returns the last changed revision of the remote repository
+// path at the configured revision, or at HEAD if no revision is configured.
+func (r *Repo) svnRemoteRevision() (string, error) {
+	type commit struct {
+		Revision string `xml:"revision,attr"`
+	}
+	type info struct {
+		Commit commit `xml:"entry>commit"`
+	}
+	rev := r.cfg.Revision
+	if rev == "" {
+		rev = "HEAD"
+	}
+	out, err := r.svnCommand("info", "--xml", "-r", rev, r.svnRemote()+"@"+rev).CombinedOutput()
+	if nil != err {
+		return "", UnknownRevisionError(
+			vcs.NewRemoteError("Unable to retrieve remote revision", err, string(out)).Error())
+	}
+	inf := &info{}
+	if err := xml.Unmarshal(out, inf); nil != err || inf.Commit.Revision == "" {
+		return "", UnknownRevisionError(r.Remote())
+	}
+	return inf.Commit.Revision, nil
+}
+
+// export retrieves a clean tree of the remote repository, without any VCS
+// metadata, into the local path, replacing all of its existing content.
+// The revision exported is resolved beforehand so that it can be recorded
+// exactly, even if HEAD changes during the export.
+func (r *Repo) export() error {
+	local := filepath.Clean(r.LocalPath())
+	if abs, err := filepath.Abs(local); nil != err ||
+		local == "." || abs == filepath.Dir(abs) {
+		return errors.New("refusing to replace local path: " + r.LocalPath())
+	}
+	rev, err := r.svnRemoteRevision()
+	if nil != err {
+		return err
+	}
+	if err := os.RemoveAll(local); nil != err {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0755); nil != err {
+		return err
+	}
+	args := append(r.svnRetrieveArgs(), "-r", rev)
+	if r.cfg.Depth != "" {
+		args = append(args, "--depth", r.cfg.Depth)
+	}
+	if len(r.cfg.Paths) == 0 {
+		out, err := r.output(r.svnCommand("export", append(args, r.svnRemote()+"@"+rev, local)...))
+		if nil != err {
+			return vcs.NewRemoteError("Unable to export repository", err, string(out))
+		}
+	}
+	// sparse export: each configured subtree is exported individually into the
+	// corresponding path of the local tree.
+	for _, p := range r.cfg.Paths {
+		dst := filepath.Join(local, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); nil != err {
+			return err
+		}
+		out, err := r.output(r.svnCommand("export",
+			append(args, r.svnRemote()+"/"+path.Clean(p)+"@"+rev, dst)...))
+		if nil != err {
+			return vcs.NewRemoteError("Unable to export repository path", err, string(out))
+		}
+	}
+	r.exported = rev
+	return nil
+}
+
+// Ping returns true if and only if the remote repository is accessible.
+func (r *Repo) Ping() bool {
+	return nil == r.ping()
+}
+
+// ping returns ConnectionFailedError, with the output of the failed command,
+// if the remote repository is not accessible.
+func (r *Repo) ping() error {
+	var cmd *exec.Cmd
+	switch {
+	case r.isSvn():
+		args := r.svnArgs("info", r.Remote())
+		if r.cfg.Username == "" && r.cfg.Password == "" {
+			args = append([]string{"--non-interactive"}, args...)
+		}
+		cmd = r.command(r.svn.Executable(), args...)
+	case r.Vcs() == vcs.Git:
+		// fail rather than prompt for credentials, as the vcs library does.
+		cmd = r.command("git", "ls-remote", r.Remote())
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	default:
+		if !r.Repo.Ping() {
+			return ConnectionFailedError{Msg: r.Remote()}
+		}
+		return nil
+	}
+	if out, err := cmd.CombinedOutput(); nil != err {
+		return ConnectionFailedError{Msg: r.Remote(), Output: strings.TrimSpace(string(out))}
+	}
+	return nil
+}
+
+// svnVersion returns the revision of the local working copy.
+func (r *Repo) svnVersion() (string, error) {
+	type commit struct {
+		Revision string `xml:"revision,attr"`
+	}
+	type info struct {
+		Commit commit `xml:"entry>commit"`
+	}
+	out, err := r.svnCommandFromDir("info", "--xml").CombinedOutput()
+	if nil != err {
+		return "", vcs.NewLocalError("Unable to retrieve checked out version", err, string(out))
+	}
+	inf := &info{}
+	if err := xml.Unmarshal(out, inf); nil != err {
+		return "", vcs.NewLocalError("Unable to retrieve checked out version", err, string(out))
+	}
+	return inf.Commit.Revision, nil
+}
+
+// svnRepo is an SVN vcs.Repo using a configured svn executable, for which the
+// vcs library makes no provision: its constructor and methods always run the
+// svn executable found in PATH.
+// Only the methods used by Repo with SVN repositories are implemented. Every
+// svn command is run by Repo itself (see svnCommand), so the remaining methods
+// of the embedded vcs.SvnRepo, which has neither a remote nor a local path, must
+// not be called.
+type svnRepo struct {
+	vcs.SvnRepo
+	remote string
+	local  string
+}
+
+// newSvnRepo returns a new svnRepo with the given remote URL and local path,
+// verifying that the svn executable configured by svn exists and that the
+// remote URL of an existing local working copy is the given remote URL, as
+// vcs.NewSvnRepo does.
+func newSvnRepo(svn config.SvnConfig, remote, local string) (*svnRepo, error) {
+	if _, err := exec.LookPath(svn.Executable()); nil != err {
+		return nil, vcs.NewLocalError("svn is not installed", err, svn.Executable())
+	}
+	r := &svnRepo{remote: remote, local: local}
+	if !r.CheckLocal() {
+		return r, nil
+	}
+	type info struct {
+		Url string `xml:"entry>url"`
+	}
+	args := append(append([]string{"info", "--xml"}, svn.Args...), local)
+	out, err := exec.Command(svn.Executable(), args...).CombinedOutput()
+	if nil != err {
+		return nil, vcs.NewLocalError("Unable to retrieve local repo information", err, string(out))
+	}
+	inf := &info{}
+	if err := xml.Unmarshal(out, inf); nil != err {
+		return nil, vcs.NewLocalError("Unable to retrieve local repo information", err, string(out))
+	}
+	if inf.Url != "" && remote != "" && inf.Url != remote {
+		return nil, vcs.ErrWrongRemote
+	}
+	return r, nil
+}
+
+// Remote returns the remote URL of the receiver.
+func (s *svnRepo) Remote() string {
+	return s.remote
+}
+
+// LocalPath returns the local path of the receiver.
+func (s *svnRepo) LocalPath() string {
+	return s.local
+}
+
+// CheckLocal returns true if and only if the local path is an SVN working copy.
+func (s *svnRepo) CheckLocal() bool {
+	_, err := os.Stat(filepath.Join(s.local, ".svn"))
+	return nil == err
+}
+
+// CmdFromDir returns the command running cmd with the given arguments from the
+// local path.
+func (s *svnRepo) CmdFromDir(cmd string, args ...string) *exec.Cmd {
+	c := exec.Command(cmd, args...)
+	c.Dir = s.local
+	return c
+}
+
+// RunFromDir runs cmd with the given arguments from the local path, returning
+// its combined output.
+func (s *svnRepo) RunFromDir(cmd string, args ...string) ([]byte, error) {
+	return s.CmdFromDir(cmd, args...).CombinedOutput()
+}
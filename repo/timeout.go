@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// killGrace is the maximum duration to wait for a timed out operation to
+// return once its commands have been killed.
+const killGrace = 5 * time.Second
+
+// timeoutError is returned by deadline if its operation does not return before
+// the receiver's timeout.
+type timeoutError struct {
+	elapsed time.Duration
+}
+
+// Error returns the string representation of timeoutError
+func (e timeoutError) Error() string {
+	return "timed out after " + e.elapsed.Round(time.Millisecond).String()
+}
+
+// SetTimeout sets the maximum duration of each network operation (connection,
+// export, and remote revision query), including all of its retries.
+// If d is zero or negative, operations are never timed out.
+func (r *Repo) SetTimeout(d time.Duration) {
+	r.timeout = d
+}
+
+// context returns the context of the network operation currently running, or
+// the background context if no operation is running.
+func (r *Repo) context() context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if nil == r.ctx {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// setContext sets the context returned by context.
+func (r *Repo) setContext(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx = ctx
+}
+
+// command returns the command running name with the given arguments, which is
+// killed if the network operation running it times out (see deadline).
+func (r *Repo) command(name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(r.context(), name, args...)
+}
+
+// deadline calls op with a context that is done once the receiver's timeout
+// elapses, and returns the error of op, or timeoutError if op does not return
+// before the timeout.
+// Commands created with the receiver's command method while op is running are
+// killed when the timeout elapses, and op is given a short grace period to
+// return afterwards. Git checkouts and updates are run by the vcs library,
+// which cannot kill them; an operation blocked on such a command is abandoned,
+// and it is expected that the caller does not reuse the receiver afterwards.
+// The caller must not read any variable written by op if timeoutError is
+// returned.
+func (r *Repo) deadline(op func(ctx context.Context) error) error {
+	if r.timeout <= 0 {
+		return op(context.Background())
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	r.setContext(ctx)
+	done := make(chan error, 1)
+	go func() { done <- op(ctx) }()
+	select {
+	case err := <-done:
+		r.setContext(nil)
+		return err
+	case <-ctx.Done():
+	}
+	// the context remains set (and done) so that any command the abandoned
+	// operation creates afterwards fails immediately.
+	select {
+	case <-done:
+	case <-time.After(killGrace):
+	}
+	return timeoutError{elapsed: time.Since(start)}
+}
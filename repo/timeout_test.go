@@ -0,0 +1,63 @@
+// +build !windows
+
+package repo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ardnew/svngrab/config"
+)
+
+// TestTimeoutKillsCommand verifies that a network operation that times out
+// kills its svn command rather than waiting for it to exit.
+func TestTimeoutKillsCommand(t *testing.T) {
+	dir := t.TempDir()
+	svn := filepath.Join(dir, "svn")
+	pid := filepath.Join(dir, "pid")
+	script := "#!/bin/sh\necho $$ >" + pid + "\nexec sleep 30\n"
+	if err := ioutil.WriteFile(svn, []byte(script), 0755); nil != err {
+		t.Fatal(err)
+	}
+	cfg := config.ExportConfig{
+		Type:  "svn",
+		Repo:  "file:///nonexistent",
+		Path:  "trunk",
+		Local: filepath.Join(dir, "wc"),
+	}
+	r, err := New(cfg, config.SvnConfig{Path: svn})
+	if nil != err {
+		t.Fatal(err)
+	}
+	r.SetTimeout(100 * time.Millisecond)
+	for name, op := range map[string]func() error{
+		"connect": func() error { _, err := r.IsConnected(2, nil); return err },
+		"export":  func() error { return r.Export(2, nil) },
+	} {
+		start := time.Now()
+		err := op()
+		if elapsed := time.Since(start); elapsed >= killGrace {
+			t.Errorf("%s: returned after %s, command was not killed", name, elapsed)
+		}
+		if nil == err || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("%s: expected timeout error, got: %v", name, err)
+		}
+		b, err := ioutil.ReadFile(pid)
+		if nil != err {
+			t.Fatal(err)
+		}
+		p, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if nil != err {
+			t.Fatal(err)
+		}
+		if nil == syscall.Kill(p, 0) {
+			syscall.Kill(p, syscall.SIGKILL)
+			t.Errorf("%s: command still running after timeout", name)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package run
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mholt/archiver/v3"
+)
+
+// appendable returns true if entries may be added to an existing archive
+// written by the given archiver (see appendArchive). Entries of compressed
+// tarballs cannot be added without decompressing and recompressing the entire
+// archive, so only plain tar and zip archives are appendable.
+func appendable(arc archiver.Archiver) bool {
+	switch arc.(type) {
+	case *archiver.Tar, *archiver.Zip:
+		return true
+	}
+	return false
+}
+
+// appendArchive writes an archive at out containing each entry of the existing
+// archive at base, followed by each entry of the archive written by calling
+// write with the path of a new temporary file. Entries of base with the same
+// name as an added entry are omitted, so that added entries replace them.
+// The archive at base is not modified.
+func appendArchive(arc archiver.Archiver, base, out string, write func(tmp string) error) error {
+	add := filepath.Join(filepath.Dir(out), ".append"+filepath.Base(out))
+	if err := write(add); nil != err {
+		return err
+	}
+	defer os.Remove(add)
+	switch arc.(type) {
+	case *archiver.Tar:
+		return appendTar(base, add, out)
+	case *archiver.Zip:
+		return appendZip(base, add, out)
+	}
+	return InvalidCompressMethod("cannot append to archive: " + base)
+}
+
+// entryName returns the given archive entry name without the trailing slash
+// of directories, for comparison of entries.
+func entryName(name string) string {
+	return strings.TrimSuffix(name, "/")
+}
+
+// appendTar writes the tar archive at out with the entries of the tar archives
+// at base and add (see appendArchive).
+func appendTar(base, add, out string) error {
+
+	// collect the names of each added entry.
+	added := map[string]bool{}
+	err := readTar(add, func(hdr *tar.Header, r io.Reader) error {
+		added[entryName(hdr.Name)] = true
+		return nil
+	})
+	if nil != err {
+		return err
+	}
+
+	f, err := os.Create(out)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	copyEntry := func(hdr *tar.Header, r io.Reader) error {
+		if err := tw.WriteHeader(hdr); nil != err {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	}
+	err = readTar(base, func(hdr *tar.Header, r io.Reader) error {
+		if added[entryName(hdr.Name)] {
+			return nil
+		}
+		return copyEntry(hdr, r)
+	})
+	if nil == err {
+		err = readTar(add, copyEntry)
+	}
+	if nil != err {
+		return err
+	}
+	if err := tw.Close(); nil != err {
+		return err
+	}
+	return f.Close()
+}
+
+// readTar calls fn with the header and content of each entry of the tar
+// archive at path, in order.
+func readTar(path string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if io.EOF == err {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+		if err := fn(hdr, tr); nil != err {
+			return err
+		}
+	}
+}
+
+// appendZip writes the zip archive at out with the entries of the zip archives
+// at base and add (see appendArchive). Each entry retains its header (see
+// copyZipEntry).
+func appendZip(base, add, out string) error {
+
+	zb, err := zip.OpenReader(base)
+	if nil != err {
+		return err
+	}
+	defer zb.Close()
+	za, err := zip.OpenReader(add)
+	if nil != err {
+		return err
+	}
+	defer za.Close()
+
+	added := map[string]bool{}
+	for _, zf := range za.File {
+		added[entryName(zf.Name)] = true
+	}
+	files := make([]*zip.File, 0, len(zb.File)+len(za.File))
+	for _, zf := range zb.File {
+		if !added[entryName(zf.Name)] {
+			files = append(files, zf)
+		}
+	}
+	files = append(files, za.File...)
+
+	f, err := os.Create(out)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, zf := range files {
+		if err := copyZipEntry(zw, zf); nil != err {
+			return err
+		}
+	}
+	if err := zw.Close(); nil != err {
+		return err
+	}
+	return f.Close()
+}
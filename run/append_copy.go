@@ -0,0 +1,26 @@
+// +build !go1.17
+
+package run
+
+import (
+	"archive/zip"
+	"io"
+)
+
+// copyZipEntry writes the given zip file entry, with the same header, to the
+// given zip archive. Raw entries cannot be copied before Go 1.17, so the
+// content is decompressed and recompressed with the same method.
+func copyZipEntry(zw *zip.Writer, zf *zip.File) error {
+	hdr := zf.FileHeader
+	w, err := zw.CreateHeader(&hdr)
+	if nil != err {
+		return err
+	}
+	r, err := zf.Open()
+	if nil != err {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
@@ -0,0 +1,25 @@
+// +build go1.17
+
+package run
+
+import (
+	"archive/zip"
+	"io"
+)
+
+// copyZipEntry writes the given zip file entry, with the same header and
+// compressed content, to the given zip archive, without decompressing and
+// recompressing its content.
+func copyZipEntry(zw *zip.Writer, zf *zip.File) error {
+	r, err := zf.OpenRaw()
+	if nil != err {
+		return err
+	}
+	hdr := zf.FileHeader
+	w, err := zw.CreateRaw(&hdr)
+	if nil != err {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
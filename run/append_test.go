@@ -0,0 +1,96 @@
+package run
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeZip writes a zip archive at path with the given entries, keyed by name,
+// deflated.
+func writeZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if nil != err {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestAppendZip(t *testing.T) {
+	dir := t.TempDir()
+	base, add, out := filepath.Join(dir, "base.zip"), filepath.Join(dir, "add.zip"), filepath.Join(dir, "out.zip")
+	writeZip(t, base, map[string]string{
+		"kept.txt":     strings.Repeat("kept ", 100),
+		"replaced.txt": "old",
+	})
+	writeZip(t, add, map[string]string{
+		"replaced.txt": "new",
+		"added.txt":    "added",
+	})
+	if err := appendZip(base, add, out); nil != err {
+		t.Fatalf("appendZip: %v", err)
+	}
+
+	zb, err := zip.OpenReader(base)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer zb.Close()
+	zo, err := zip.OpenReader(out)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer zo.Close()
+
+	want := map[string]string{
+		"kept.txt":     strings.Repeat("kept ", 100),
+		"replaced.txt": "new",
+		"added.txt":    "added",
+	}
+	if len(zo.File) != len(want) {
+		t.Errorf("appended archive has %d entries, want %d", len(zo.File), len(want))
+	}
+	for _, zf := range zo.File {
+		r, err := zf.Open()
+		if nil != err {
+			t.Fatalf("%s: %v", zf.Name, err)
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if nil != err {
+			t.Fatalf("%s: %v", zf.Name, err)
+		}
+		if string(data) != want[zf.Name] {
+			t.Errorf("%s: content %q, want %q", zf.Name, data, want[zf.Name])
+		}
+		// the existing entry is copied with its original compressed content.
+		if zf.Name == "kept.txt" {
+			orig := zb.File[0]
+			if orig.Name != zf.Name {
+				orig = zb.File[1]
+			}
+			if zf.Method != zip.Deflate || zf.CompressedSize64 != orig.CompressedSize64 ||
+				zf.CRC32 != orig.CRC32 {
+				t.Errorf("%s: method %d, compressed size %d, want method %d, size %d",
+					zf.Name, zf.Method, zf.CompressedSize64, orig.Method, orig.CompressedSize64)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mholt/archiver/v3"
+)
+
+// topLevelFolder returns the name of the folder containing all entries of the
+// archive at arcPath of the given package path, as configured by the compress
+// configuration's TopLevelFolder field.
+func topLevelFolder(pkgPath, arcPath string, cfg string) string {
+	switch strings.ToLower(cfg) {
+	case "", "false":
+		return filepath.Base(filepath.Clean(pkgPath))
+	case "true":
+		return trimArchiveExt(filepath.Base(arcPath))
+	}
+	return path.Clean(filepath.ToSlash(cfg))
+}
+
+// archiveTree writes the contents of the given package path to an archive at
+// arcPath, in the same layout as archiver.Archiver.Archive, except that all
+// entries are contained in the folder named top instead of the base name of
+// the package path.
+// The archive is written to arcPath directly, which must not already exist
+// (see writeAtomic).
+// If reproducible is true, the archive is written such that identical content
+// always produces an identical archive: entries are sorted by path, and every
+// entry has the same modification time and no owner.
+// If skip is non-nil, entries that cannot be written are passed to skip with
+// their error and omitted, rather than failing the archive.
+// If deref is true, each symbolic link is archived as the file it refers to.
+// Entries are ordered as described by order (see sortEntries).
+func archiveTree(arc archiver.Writer, pkgPath, arcPath, top, order string, reproducible, deref bool, skip func(name string, err error)) error {
+
+	// collect the name of each entry in the archive, relative to the top-level
+	// folder, and the path of its source file.
+	source := map[string]string{}
+	err := filepath.Walk(pkgPath, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		rel, err := filepath.Rel(pkgPath, path)
+		if nil != err {
+			return err
+		}
+		name := top
+		if rel != "." {
+			name += "/" + filepath.ToSlash(rel)
+		}
+		source[name] = path
+		return nil
+	})
+	if nil != err {
+		return err
+	}
+	return writeArchive(arc, arcPath, source, order, reproducible, deref, skip)
+}
+
+// writeArchive writes an archive at arcPath containing an entry for each name
+// in source, ordered by order (see sortEntries), with the content and metadata
+// of its source path.
+// An entry whose source path is empty is a directory without any metadata.
+// If reproducible is true, every entry has the same modification time and no
+// owner, and if skip is non-nil, entries that cannot be written are skipped
+// (see archiveTree). If deref is true, symbolic links are dereferenced.
+func writeArchive(arc archiver.Writer, arcPath string, source map[string]string, order string, reproducible, deref bool, skip func(name string, err error)) error {
+
+	names := sortEntries(source, order, deref)
+
+	out, err := os.Create(arcPath)
+	if nil != err {
+		return err
+	}
+	defer out.Close()
+	if err := arc.Create(out); nil != err {
+		return err
+	}
+
+	var epoch time.Time
+	if reproducible {
+		epoch = reproducibleEpoch()
+	}
+	for _, name := range names {
+		if err := writeEntry(arc, source[name], name, epoch, deref); nil != err {
+			if nil != skip {
+				skip(name, err)
+				continue
+			}
+			arc.Close()
+			return err
+		}
+	}
+	if err := arc.Close(); nil != err {
+		return err
+	}
+	return out.Close()
+}
+
+// sortEntries returns the names of the given archive entries, keyed by name
+// with the path of their source file, in the given order: by name ("path" or
+// empty), or by the size ("size") or modification time ("mtime") of their
+// source file, ascending, and then by name. Directories, and entries whose
+// source file cannot be read (or is empty), are ordered first by name, so that
+// each directory precedes its content.
+func sortEntries(source map[string]string, order string, deref bool) []string {
+	names := make([]string, 0, len(source))
+	for name := range source {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var key func(info os.FileInfo) int64
+	switch strings.ToLower(order) {
+	case "size":
+		key = func(info os.FileInfo) int64 { return info.Size() }
+	case "mtime":
+		key = func(info os.FileInfo) int64 { return info.ModTime().UnixNano() }
+	default:
+		return names
+	}
+	stat := os.Lstat
+	if deref {
+		stat = os.Stat
+	}
+	keys := make(map[string]int64, len(names))
+	for _, name := range names {
+		keys[name] = math.MinInt64
+		if info, err := stat(source[name]); nil == err && source[name] != "" && !info.IsDir() {
+			keys[name] = key(info)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return keys[names[i]] < keys[names[j]]
+	})
+	return names
+}
+
+// writeAtomic calls write with the path of a new temporary file in the same
+// directory as path, and then renames the temporary file to path, so that path
+// never refers to an incomplete file. The temporary file has the same
+// extension as path, and it does not exist when write is called. If write
+// fails, the temporary file is removed and path is left unchanged. Returns an
+// error without calling write if path already exists and overwrite is false.
+func writeAtomic(path string, overwrite bool, write func(tmp string) error) error {
+	if _, err := os.Stat(path); nil == err && !overwrite {
+		return fmt.Errorf("file already exists: %s", path)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); nil != err {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".*-"+filepath.Base(path))
+	if nil != err {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	if err := os.Remove(tmp); nil != err {
+		return err
+	}
+	if err := write(tmp); nil != err {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); nil != err {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeEntry writes a single file to the given archive with the given name.
+// If path is empty, the entry is a directory with default permissions.
+// If modTime is non-zero, the entry has the given modification time and no
+// system-specific metadata (see fixedFileInfo); otherwise, the metadata of the
+// file is retained.
+// If deref is true and path is a symbolic link, the entry is the file to which
+// it refers rather than the link itself.
+func writeEntry(arc archiver.Writer, path, name string, modTime time.Time, deref bool) error {
+	if path == "" {
+		if modTime.IsZero() {
+			modTime = time.Now()
+		}
+		return arc.Write(archiver.File{FileInfo: dirFileInfo{name: name, modTime: modTime}})
+	}
+	stat := os.Lstat
+	if deref {
+		stat = os.Stat
+	}
+	info, err := stat(path)
+	if nil != err {
+		return err
+	}
+	// reject special files (e.g., sockets) before writing anything, so that the
+	// entry may be skipped without corrupting the archive.
+	if mode := info.Mode(); !mode.IsDir() && !mode.IsRegular() && mode&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s: unsupported file type: %s", path, mode.Type())
+	}
+	file := archiver.File{FileInfo: renamedFileInfo{FileInfo: info, name: name}}
+	if !modTime.IsZero() {
+		file.FileInfo = fixedFileInfo{FileInfo: info, name: name, modTime: modTime}
+	}
+	if info.Mode().IsRegular() {
+		f, err := os.Open(path)
+		if nil != err {
+			return err
+		}
+		defer f.Close()
+		file.ReadCloser = f
+	}
+	return arc.Write(file)
+}
+
+// renamedFileInfo is an os.FileInfo with a custom name.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string { return fi.name }
+
+// dirFileInfo is an os.FileInfo of a directory that does not exist on disk.
+type dirFileInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi dirFileInfo) Name() string       { return fi.name }
+func (fi dirFileInfo) Size() int64        { return 0 }
+func (fi dirFileInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (fi dirFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi dirFileInfo) IsDir() bool        { return true }
+func (fi dirFileInfo) Sys() interface{}   { return nil }
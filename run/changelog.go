@@ -0,0 +1,66 @@
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// changelogName is the name of the changelog file written into a package.
+const changelogName = "CHANGELOG"
+
+// writeChangelog writes a file into the given package path listing the
+// commits made to each of the named repositories between their previous and
+// current exported revisions, logging its progress.
+// Repositories without a previous revision (i.e., exported for the first
+// time) or without any new revisions are skipped.
+func (p *packager) writeChangelog(pkgPath string, names []string) error {
+
+	l := p.l
+
+	var sb strings.Builder
+	for _, name := range names {
+		rev, ok := p.revs[name]
+		switch {
+		case !ok || rev.from == "":
+			l.Infof("clog", "%s (skipped, first export)", name)
+			l.Break()
+			continue
+		case rev.from == rev.to:
+			l.Infof("clog", "%s (skipped, unchanged)", name)
+			l.Break()
+			continue
+		}
+		l.Infof("clog", "%s: %s -> %s", name, rev.from, rev.to)
+		commits, err := p.reps[name].Log(rev.from, rev.to)
+		l.Eolf("clog", err, " (%d commits)", len(commits))
+		if nil != err {
+			return err
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%s (%s -> %s)\n", name, rev.from, rev.to)
+		for _, c := range commits {
+			fmt.Fprintf(&sb, "\n%s | %s | %s\n", c.Commit, c.Author,
+				c.Date.Format("2006-01-02 15:04:05 -0700"))
+			for _, line := range strings.Split(c.Message, "\n") {
+				sb.WriteString("    " + strings.TrimRight(line, "\r") + "\n")
+			}
+		}
+	}
+	if sb.Len() == 0 {
+		return nil
+	}
+
+	logPath := filepath.Join(pkgPath, changelogName)
+	l.Infof("clog", "writing changelog: %s ...", logPath)
+	err := os.MkdirAll(pkgPath, 0755)
+	if nil == err {
+		err = ioutil.WriteFile(logPath, []byte(sb.String()), 0644)
+	}
+	l.Eolf("clog", err, " (ok)")
+	return err
+}
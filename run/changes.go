@@ -0,0 +1,34 @@
+package run
+
+import (
+	"sort"
+
+	"github.com/ardnew/svngrab/log"
+	"github.com/ardnew/svngrab/repo"
+)
+
+// listChanges records the paths changed in each of the given repositories
+// between the given revisions, logging its progress. The remaining
+// repositories are skipped after the first failure, whose error is returned.
+func listChanges(l *log.Log, sum *summary, reps map[string]*repo.Repo, revs map[string]revRange) error {
+	names := make([]string, 0, len(revs))
+	for name := range revs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rev := revs[name]
+		from := rev.from
+		if from == "" {
+			from = "(none)"
+		}
+		l.Infof("chng", "%s: %s -> %s", name, from, rev.to)
+		paths, err := reps[name].ChangedPaths(rev.from, rev.to)
+		l.Eolf("chng", err, " (%d paths)", len(paths))
+		if nil != err {
+			return err
+		}
+		sum.setPaths(name, paths)
+	}
+	return nil
+}
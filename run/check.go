@@ -0,0 +1,84 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Check verifies the configuration file of the given options without
+// exporting, copying, or writing anything: the configuration is parsed and
+// validated, variable substitution is performed, the connection to each
+// remote repository is verified, and the compression method and level of each
+// package archive is verified. The result of each check is written to w as an
+// aligned table.
+// Returns the configuration error, if it cannot be parsed, or else a
+// MultiError containing every failed check.
+func Check(o Options, w io.Writer) error {
+
+	o = o.normalize()
+	l := o.Log
+
+	cfg, err := parseConfig(l, o.ConfigPath)
+	if nil != err {
+		return err
+	}
+
+	ex := newExpander(o.StrictVars, o.Variables)
+
+	exports, packages, err := selectConfig(l, cfg, ex, o.Only, o.Except)
+	if nil != err {
+		return err
+	}
+
+	cacheDir := ex.expand(cfg.CacheDir)
+	svn := svnCommand(ex, cfg, o)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCHECK\tRESULT")
+
+	var errs MultiError
+	report := func(name, check string, err error) {
+		result := "ok"
+		if nil != err {
+			result = err.Error()
+			errs = append(errs, err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, check, result)
+	}
+
+	names := make([]string, 0, len(exports))
+	for name := range exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		name, expo := expandExport(ex, cacheDir, key, exports[key])
+		err := ex.check(l)
+		if nil == err {
+			_, err = openRepo(l, name, expo, svn, o.Retries, o.Timeout)
+		}
+		report(name, "repository", err)
+	}
+
+	names = make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		if pkg := packages[key]; pkg.Compress.Output != "" {
+			_, _, err := makeArchiver(key, pkg.Compress)
+			report(ex.expand(key), "compress", err)
+		}
+	}
+
+	if err := tw.Flush(); nil != err {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
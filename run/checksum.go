@@ -0,0 +1,101 @@
+package run
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// newHash returns a new hash.Hash for the named checksum algorithm.
+func newHash(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "sha256":
+		return sha256.New(), nil
+	case "md5":
+		return md5.New(), nil
+	}
+	return nil, InvalidChecksumAlgo(algo)
+}
+
+// checksumPath returns the path of the sidecar checksum file for the given
+// file path and checksum algorithm.
+func checksumPath(filePath, algo string) string {
+	return filePath + "." + strings.ToLower(algo)
+}
+
+// fileChecksum returns the hex-encoded checksum of the given file using the
+// named algorithm.
+func fileChecksum(filePath, algo string) (string, error) {
+	h, err := newHash(algo)
+	if nil != err {
+		return "", err
+	}
+	f, err := os.Open(filePath)
+	if nil != err {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); nil != err {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checksumKey returns the name of the shell environment variable holding the
+// checksum of the given package's archive computed with the named algorithm:
+// REPO_<name>_<ALGO>, where name is the base name of the package path with each
+// character that is not valid in an identifier replaced by an underscore.
+func checksumKey(pkgPath, algo string) string {
+	return "REPO_" + shellEnvKey(filepath.Base(pkgPath)) + "_" + strings.ToUpper(algo)
+}
+
+// writeChecksum computes the checksum of the given file using the named
+// algorithm and writes it to a sidecar file in the standard format used by
+// sha256sum(1) and md5sum(1): "HASH  filename". The sidecar file is replaced
+// atomically (see writeAtomic).
+// Returns the hex-encoded checksum.
+func writeChecksum(filePath, algo string) (string, error) {
+	sum, err := fileChecksum(filePath, algo)
+	if nil != err {
+		return "", err
+	}
+	line := sum + "  " + filepath.Base(filePath) + "\n"
+	return sum, writeAtomic(checksumPath(filePath, algo), true, func(tmp string) error {
+		return ioutil.WriteFile(tmp, []byte(line), 0644)
+	})
+}
+
+// sameContent returns true if and only if src and dst are both regular files
+// with identical SHA-256 checksums. The checksums are only computed if dst
+// exists and has the same size as src.
+func sameContent(src, dst string) (bool, error) {
+	sinfo, err := os.Lstat(src)
+	if nil != err {
+		return false, err
+	}
+	dinfo, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if nil != err {
+		return false, err
+	}
+	if !sinfo.Mode().IsRegular() || !dinfo.Mode().IsRegular() ||
+		sinfo.Size() != dinfo.Size() {
+		return false, nil
+	}
+	ssum, err := fileChecksum(src, "sha256")
+	if nil != err {
+		return false, err
+	}
+	dsum, err := fileChecksum(dst, "sha256")
+	if nil != err {
+		return false, err
+	}
+	return ssum == dsum, nil
+}
@@ -0,0 +1,47 @@
+package run
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksumEnvironment(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "file.txt"), "content")
+	out := t.TempDir()
+	pkg := filepath.Join(out, "my-pkg.v1")
+	arc := filepath.Join(out, "my-pkg.zip")
+	path := filepath.Join(t.TempDir(), "svngrab.yml")
+	writeFile(t, path, "package:\n"+
+		"  "+pkg+":\n"+
+		"    include:\n"+
+		"      - "+src+":\n"+
+		"          - copy: {repo: file.txt, package: file.txt}\n"+
+		"    compress:\n"+
+		"      output: "+arc+"\n"+
+		"      method: zip\n"+
+		"      checksum: [sha256, md5]\n")
+
+	var buf bytes.Buffer
+	env := NewShellEnv("svngrab", DotenvDialect, &buf, nil)
+	if _, err := Execute(Options{ConfigPath: path, NoWrite: true, Env: env}); nil != err {
+		t.Fatalf("Execute: %v", err)
+	}
+	script := env.String()
+	for key, algo := range map[string]string{
+		"REPO_MY_PKG_V1_SHA256": "sha256",
+		"REPO_MY_PKG_V1_MD5":    "md5",
+	} {
+		data, err := ioutil.ReadFile(checksumPath(arc, algo))
+		if nil != err {
+			t.Fatal(err)
+		}
+		hash := strings.Fields(string(data))[0]
+		if want := key + "=" + hash; !strings.Contains(script, want) {
+			t.Errorf("script does not contain %q:\n%s", want, script)
+		}
+	}
+}
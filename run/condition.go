@@ -0,0 +1,95 @@
+package run
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// InvalidCondition is raised when a "when" expression cannot be parsed.
+type InvalidCondition string
+
+// Error returns the string representation of InvalidCondition
+func (e InvalidCondition) Error() string {
+	return "invalid condition: " + string(e)
+}
+
+// Type returns the type name of InvalidCondition
+func (e InvalidCondition) Type() string {
+	return "InvalidCondition"
+}
+
+// condOperand matches a single operand of a condition: a double-quoted string,
+// a single-quoted string, or a bare word.
+const condOperand = `("(?:[^"\\]|\\.)*"|'[^']*'|[^\s"'=!]+)`
+
+var (
+	// condCompare matches a comparison of two operands.
+	condCompare = regexp.MustCompile(
+		`^\s*` + condOperand + `\s*(==|!=)\s*` + condOperand + `\s*$`)
+	// condUnary matches a single operand, optionally negated.
+	condUnary = regexp.MustCompile(`^\s*(!?)\s*` + condOperand + `\s*$`)
+)
+
+// evalCondition evaluates the given condition against the variables defined
+// by the given expander. An empty condition is always true.
+//
+// The following forms are supported, where each operand is a bare word or a
+// quoted string that may contain variable references:
+//
+//	A == B   true if A and B are equal
+//	A != B   true if A and B are not equal
+//	A        true if A is not empty
+//	!A       true if A is empty
+//
+// Undefined variables are replaced with the empty string, unless the expander
+// is strict. Variable substitution errors (e.g., undefined variables or
+// reference cycles) are retained by the given expander, to be checked by the
+// caller.
+func evalCondition(ex *expander, cond string) (bool, error) {
+	if strings.TrimSpace(cond) == "" {
+		return true, nil
+	}
+	cx := ex.within(nil)
+	cx.empty = true
+	defer func() {
+		if nil == ex.err {
+			ex.err = cx.err
+		}
+	}()
+	if m := condCompare.FindStringSubmatch(cond); nil != m {
+		lhs, err := condValue(cx, m[1])
+		if nil != err {
+			return false, InvalidCondition(cond)
+		}
+		rhs, err := condValue(cx, m[3])
+		if nil != err {
+			return false, InvalidCondition(cond)
+		}
+		return (lhs == rhs) == (m[2] == "=="), nil
+	}
+	if m := condUnary.FindStringSubmatch(cond); nil != m {
+		val, err := condValue(cx, m[2])
+		if nil != err {
+			return false, InvalidCondition(cond)
+		}
+		return (val != "") == (m[1] == ""), nil
+	}
+	return false, InvalidCondition(cond)
+}
+
+// condValue returns the value of the given operand, with quotes removed and
+// variable references expanded by the given expander.
+func condValue(ex *expander, operand string) (string, error) {
+	switch {
+	case strings.HasPrefix(operand, `"`):
+		s, err := strconv.Unquote(operand)
+		if nil != err {
+			return "", err
+		}
+		operand = s
+	case strings.HasPrefix(operand, `'`):
+		operand = operand[1 : len(operand)-1]
+	}
+	return ex.expand(operand), nil
+}
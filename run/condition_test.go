@@ -0,0 +1,62 @@
+package run
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEvalCondition(t *testing.T) {
+	vars := map[string]string{"$FLAVOR": "debug", "$LOOP": "$LOOP"}
+	revs := map[string]string{"$REPO_app_PREVREV": "1", "$REPO_app_CURRREV": "2"}
+	tests := []struct {
+		cond   string
+		strict bool
+		want   bool
+		err    bool // expander retains an error
+	}{
+		{"", false, true, false},
+		{`$FLAVOR == debug`, false, true, false},
+		{`$FLAVOR != "debug"`, false, false, false},
+		{`$REPO_app_CURRREV != $REPO_app_PREVREV`, false, true, false},
+		{`$REPO_app_CURRREV == '2'`, false, true, false},
+		{`$SVNGRAB_UNDEFINED`, false, false, false},
+		{`!$SVNGRAB_UNDEFINED`, false, true, false},
+		{`$SVNGRAB_UNDEFINED == ""`, true, true, true},
+		{`$LOOP`, false, true, true},
+	}
+	for _, tt := range tests {
+		ex := newExpander(tt.strict, vars).within(revs)
+		got, err := evalCondition(ex, tt.cond)
+		if nil != err {
+			t.Errorf("evalCondition(%q): %v", tt.cond, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("evalCondition(%q) = %t, want %t", tt.cond, got, tt.want)
+		}
+		if (nil != ex.err) != tt.err {
+			t.Errorf("evalCondition(%q): expander error %v, want error %t", tt.cond, ex.err, tt.err)
+		}
+	}
+}
+
+// TestCopyConditionBeforePaths verifies that a copy operation whose condition
+// evaluates false is skipped before its paths are validated.
+func TestCopyConditionBeforePaths(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, filepath.Join(src, "file.txt"), "content")
+	pkg := filepath.Join(t.TempDir(), "pkg")
+	for when, unsafe := range map[string]bool{
+		`$SVNGRAB_UNDEFINED`:  false,
+		`!$SVNGRAB_UNDEFINED`: true,
+	} {
+		err := executeConfig(t, "package:\n"+
+			"  "+pkg+":\n"+
+			"    include:\n"+
+			"      - "+src+":\n"+
+			"          - copy: {repo: file.txt, package: ../outside, when: '"+when+"'}\n")
+		if _, ok := err.(UnsafeDestinationError); ok != unsafe {
+			t.Errorf("when %q: Execute = %v, want unsafe destination error %t", when, err, unsafe)
+		}
+	}
+}
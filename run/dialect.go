@@ -0,0 +1,143 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ardnew/svngrab/log"
+)
+
+// Dialect represents the shell syntax used to write the exported environment.
+type Dialect int
+
+// Constant values of enumerated type Dialect.
+const (
+	ShDialect         Dialect = iota // POSIX sh (bash, zsh, ...)
+	FishDialect                      // fish
+	PowerShellDialect                // PowerShell
+	CmdDialect                       // Windows cmd.exe batch
+	DotenvDialect                    // dotenv (.env) KEY=value
+	GitHubDialect                    // GitHub Actions output file ($GITHUB_OUTPUT)
+)
+
+// String returns the string representation of the receiver Dialect.
+func (d Dialect) String() string {
+	return []string{"sh", "fish", "powershell", "cmd", "dotenv", "github"}[d]
+}
+
+// ParseDialect returns the Dialect with the given name (case-insensitive).
+// Returns InvalidShellDialect if the name is not recognized.
+func ParseDialect(name string) (Dialect, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "sh", "bash", "zsh":
+		return ShDialect, nil
+	case "fish":
+		return FishDialect, nil
+	case "powershell", "pwsh":
+		return PowerShellDialect, nil
+	case "cmd", "bat":
+		return CmdDialect, nil
+	case "dotenv", "env", ".env":
+		return DotenvDialect, nil
+	case "github", "github-actions", "gha":
+		return GitHubDialect, nil
+	}
+	return ShDialect, InvalidShellDialect(name)
+}
+
+// DialectOf returns the Dialect implied by the name of the given file path,
+// which is GitHubDialect for the file named by environment variable
+// GITHUB_OUTPUT, DotenvDialect for ".env" files (e.g., ".env" or "build.env"),
+// and ShDialect for all others.
+func DialectOf(filePath string) Dialect {
+	if out := os.Getenv("GITHUB_OUTPUT"); out != "" && filePath == out {
+		return GitHubDialect
+	}
+	if strings.ToLower(filepath.Ext(filePath)) == ".env" {
+		return DotenvDialect
+	}
+	return ShDialect
+}
+
+// banner returns the comment lines, in the receiver's syntax, preceding the
+// named section of the exported environment. The dotenv and github dialects
+// have no banner.
+func (d Dialect) banner(section string) string {
+	var prefix string
+	switch d {
+	case DotenvDialect, GitHubDialect:
+		return ""
+	case CmdDialect:
+		prefix = "REM"
+	default:
+		prefix = "#"
+	}
+	return prefix + " " + log.Eol + prefix + " " + section + log.Eol + prefix + " " + log.Eol
+}
+
+// Replacers escaping the characters that are special within a double-quoted
+// string of each dialect.
+var (
+	shEscaper     = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", `$`, `\$`)
+	fishEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	pwshEscaper   = strings.NewReplacer("`", "``", `"`, "`\"", `$`, "`$")
+	dotenvEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\r", `\r`, "\n", `\n`)
+)
+
+// assign returns a statement in the receiver's syntax that exports an
+// environment variable with the given key and value (without a newline).
+// Characters in val that are special within double-quotes are escaped so that
+// the value is assigned verbatim. The cmd dialect has no such escapes, so val
+// is written as-is, and the github dialect needs none (see githubAssign).
+func (d Dialect) assign(key, val string) string {
+	switch d {
+	case FishDialect:
+		return `set -gx ` + key + ` "` + fishEscaper.Replace(val) + `"`
+	case PowerShellDialect:
+		return `$env:` + key + ` = "` + pwshEscaper.Replace(val) + `"`
+	case CmdDialect:
+		return `set "` + key + `=` + val + `"`
+	case DotenvDialect:
+		return key + `=` + dotenvQuote(val)
+	case GitHubDialect:
+		return githubAssign(key, val)
+	}
+	return key + `="` + shEscaper.Replace(val) + `"`
+}
+
+// dotenvQuote returns val quoted for a dotenv file only if necessary, i.e., if
+// it contains whitespace, quotes, or other characters interpreted by common
+// dotenv parsers. Single-quotes are preferred since their content is literal.
+func dotenvQuote(val string) string {
+	if !strings.ContainsAny(val, " \t\r\n\"'`#$\\=") {
+		return val
+	}
+	if !strings.ContainsAny(val, "'\r\n") {
+		return "'" + val + "'"
+	}
+	return `"` + dotenvEscaper.Replace(val) + `"`
+}
+
+// githubDelimiter is the delimiter of multiline values written in the github
+// dialect, extended as needed so that it never occurs in the value.
+const githubDelimiter = "SVNGRAB_EOF"
+
+// githubAssign returns a GitHub Actions output assignment of the given key and
+// value. The value is written verbatim: as "KEY=value" if it is a single line,
+// or otherwise with the multiline syntax:
+//
+//	KEY<<SVNGRAB_EOF
+//	value
+//	SVNGRAB_EOF
+func githubAssign(key, val string) string {
+	if !strings.ContainsAny(val, "\r\n") {
+		return key + "=" + val
+	}
+	delim := githubDelimiter
+	for n := 1; strings.Contains(val, delim); n++ {
+		delim = githubDelimiter + "_" + strconv.Itoa(n)
+	}
+	return key + "<<" + delim + log.Eol + val + log.Eol + delim
+}
@@ -0,0 +1,63 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShDialectEscaping(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if nil != err {
+		t.Skip("sh is not installed")
+	}
+	marker := filepath.Join(t.TempDir(), "substituted")
+	values := []string{
+		`plain`,
+		`double "quoted" value`,
+		`single 'quoted' value`,
+		`back\slash\`,
+		`trailing backslash \`,
+		"back`touch " + marker + "`tick",
+		`$(touch ` + marker + `)`,
+		`$HOME and ${HOME} and $1`,
+		`semi; colon && amp | pipe`,
+		`glob * ? [a]`,
+		`all "\` + "`" + `$ at once`,
+	}
+
+	var buf bytes.Buffer
+	env := NewShellEnv("test", ShDialect, &buf, nil)
+	for i, val := range values {
+		env.Append("values", fmt.Sprintf("VALUE_%d", i), val)
+	}
+	if _, err := env.Commit(); nil != err {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// source the script, then print each value terminated by a NUL byte.
+	script := buf.String()
+	for i := range values {
+		script += fmt.Sprintf("printf '%%s\\0' \"$VALUE_%d\"\n", i)
+	}
+	out, err := exec.Command(sh, "-c", script).Output()
+	if nil != err {
+		t.Fatalf("sh: %v\nscript:\n%s", err, script)
+	}
+	got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	if len(got) != len(values) {
+		t.Fatalf("sourced %d values, want %d:\n%q\nscript:\n%s", len(got), len(values), got, buf.String())
+	}
+	for i, val := range values {
+		if got[i] != val {
+			t.Errorf("VALUE_%d = %q, want %q", i, got[i], val)
+		}
+	}
+	if _, err := os.Stat(marker); nil == err {
+		t.Errorf("command substitution performed by script:\n%s", buf.String())
+	}
+}
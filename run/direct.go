@@ -0,0 +1,128 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ardnew/svngrab/config"
+
+	"github.com/otiai10/copy"
+)
+
+// directUnsupported returns the name of the first feature of the given package
+// configuration that requires its content to be copied into the package
+// directory, and therefore prevents it from being archived directly (see
+// directTree), or the empty string if there is none.
+func directUnsupported(pkg config.PackageConfig) string {
+	switch {
+	case pkg.Compress.Output == "":
+		return "no compress output"
+	case pkg.Changelog:
+		return "changelog"
+	case pkg.Roster:
+		return "roster"
+	case len(pkg.PreHook) > 0:
+		return "preHook"
+	}
+	for _, inc := range pkg.Include {
+		for _, list := range inc {
+			for _, op := range list {
+				switch cp := op.Copy; {
+				case cp.Flatten:
+					return "flatten"
+				case cp.Symlink:
+					return "symlink"
+				case cp.Verify:
+					return "verify"
+				case cp.PreserveOwner:
+					return "preserveOwner"
+				case cp.DirMode != "" || cp.FileMode != "":
+					return "dirMode/fileMode"
+				case !strings.EqualFold(cp.Conflict, "merge") && cp.Conflict != "":
+					return "conflict: " + cp.Conflict
+				case !strings.EqualFold(cp.Symlinks, "skip") && cp.Symlinks != "":
+					return "symlinks: " + cp.Symlinks
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// directTree collects the content of a package without copying it, so that it
+// can be archived directly from its sources.
+// Content is merged as if copied with the merge conflict action, so that each
+// file added replaces any file previously added at the same package path.
+type directTree struct {
+	source map[string]string // source of each package path, "" for directories
+}
+
+// newDirectTree returns a new directTree containing only the given package
+// path itself.
+func newDirectTree(pkgPath string) *directTree {
+	return &directTree{source: map[string]string{filepath.Clean(pkgPath): ""}}
+}
+
+// add adds the tree rooted at src to the receiver at package path dst, with
+// the same files that copy.Copy would copy with the given options. Symbolic
+// links are always skipped.
+func (t *directTree) add(src, dst string, opt copy.Options) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		if path != src && nil != opt.Skip {
+			skip, err := opt.Skip(path)
+			if nil != err {
+				return err
+			}
+			if skip {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if nil != err {
+			return err
+		}
+		t.insert(filepath.Join(dst, rel), path)
+		return nil
+	})
+}
+
+// insert adds the given package path with the given source path, along with
+// each of its parent directories not yet added.
+func (t *directTree) insert(path, source string) {
+	t.source[path] = source
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, ok := t.source[dir]; ok || dir == filepath.Dir(dir) {
+			return
+		}
+		t.source[dir] = ""
+	}
+}
+
+// entries returns the source path of each file in the receiver within the
+// given package path, keyed by its name in an archive whose entries are all
+// contained in the folder named top (see archiveTree).
+func (t *directTree) entries(pkgPath, top string) map[string]string {
+	root := filepath.Clean(pkgPath)
+	source := map[string]string{}
+	for path, src := range t.source {
+		if !within(path, root) {
+			continue
+		}
+		name := top
+		if rel, _ := filepath.Rel(root, path); rel != "." {
+			name += "/" + filepath.ToSlash(rel)
+		}
+		source[name] = src
+	}
+	return source
+}
@@ -0,0 +1,83 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/otiai10/copy"
+)
+
+// flattenCopy copies every file in the tree rooted at src directly into the
+// directory dst, discarding the source subdirectories. Files and directories
+// are skipped according to opt.Skip, and symbolic links are handled according
+// to opt.OnSymlink.
+// If two files have the same base name, the collision is resolved according
+// to the given action: "error" (the default) fails the copy, "skip" keeps only
+// the first file, and "suffix" renames each subsequent file by appending a
+// counter to its name (e.g., "tool-1.exe").
+// If non-nil, copied is called with the destination path of each file copied,
+// or that is already identical to its source if verify is true, in which case
+// it is not copied again.
+func flattenCopy(src, dst string, opt copy.Options, collision string, verify bool, copied func(path string)) error {
+	seen := map[string]bool{}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		if path != src && nil != opt.Skip {
+			skip, err := opt.Skip(path)
+			if nil != err {
+				return err
+			}
+			if skip {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 && nil != opt.OnSymlink &&
+			opt.OnSymlink(path) == copy.Skip {
+			return nil
+		}
+		name := filepath.Base(path)
+		if seen[name] {
+			switch strings.ToLower(collision) {
+			case "skip":
+				return nil
+			case "suffix":
+				name = uniqueName(name, seen)
+			default:
+				return fmt.Errorf("flatten: name collision: %s", path)
+			}
+		}
+		seen[name] = true
+		target := filepath.Join(dst, name)
+		if nil != copied {
+			copied(target)
+		}
+		if verify {
+			if same, err := sameContent(path, target); nil != err || same {
+				return err
+			}
+		}
+		return copy.Copy(path, target, opt)
+	})
+}
+
+// uniqueName returns the given file name with the smallest counter appended to
+// its base (before the extension) such that it is not in the given set.
+func uniqueName(name string, seen map[string]bool) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		if n := fmt.Sprintf("%s-%d%s", base, i, ext); !seen[n] {
+			return n
+		}
+	}
+}
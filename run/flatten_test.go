@@ -0,0 +1,57 @@
+package run
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlattenPrune(t *testing.T) {
+	for _, verify := range []bool{false, true} {
+		name := "copy"
+		if verify {
+			name = "verify"
+		}
+		t.Run(name, func(t *testing.T) {
+			src := t.TempDir()
+			writeFile(t, filepath.Join(src, "top.txt"), "top")
+			writeFile(t, filepath.Join(src, "sub", "a.txt"), "a")
+			writeFile(t, filepath.Join(src, "sub", "deep", "b.txt"), "b")
+			pkg := filepath.Join(t.TempDir(), "package")
+			writeFile(t, filepath.Join(pkg, "out", "stale.txt"), "stale")
+			writeFile(t, filepath.Join(pkg, "out", "sub", "a.txt"), "stale")
+			// identical content, so that verify skips copying it.
+			writeFile(t, filepath.Join(pkg, "out", "a.txt"), "a")
+
+			content := "package:\n" +
+				"  " + pkg + ":\n" +
+				"    prune: true\n" +
+				"    include:\n" +
+				"      - " + src + ":\n" +
+				"          - copy: {repo: ., package: out/, flatten: true"
+			if verify {
+				content += ", verify: true"
+			}
+			// run twice, so that the second run prunes its own earlier output.
+			for run := 0; run < 2; run++ {
+				if err := executeConfig(t, content+"}\n"); nil != err {
+					t.Fatalf("Execute: %v", err)
+				}
+			}
+
+			for file, want := range map[string]string{"top.txt": "top", "a.txt": "a", "b.txt": "b"} {
+				path := filepath.Join(pkg, "out", file)
+				if data, err := ioutil.ReadFile(path); nil != err || string(data) != want {
+					t.Errorf("%s: content %q (%v), want %q", path, data, err, want)
+				}
+			}
+			for _, file := range []string{"stale.txt", "sub"} {
+				path := filepath.Join(pkg, "out", file)
+				if _, err := os.Lstat(path); !os.IsNotExist(err) {
+					t.Errorf("%s: not pruned (%v)", path, err)
+				}
+			}
+		})
+	}
+}
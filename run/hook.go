@@ -0,0 +1,61 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// Environment variables defined for each hook command, in addition to those of
+// the process and the shell environment script.
+const (
+	hookPackageEnv = "SVNGRAB_PACKAGE" // path of the package
+	hookArchiveEnv = "SVNGRAB_ARCHIVE" // path of the package archive, if any
+)
+
+// runHooks runs each of the given hook commands of the package at pkgPath in
+// order, logging each command and its combined output.
+// Variables in each command are expanded with the given expander, and the
+// content of the shell environment script is exported into its environment,
+// along with the package path and, if non-empty, the archive path.
+// Returns HookFailedError if any command fails, without running the rest.
+func (p *packager) runHooks(ex *expander, pkgPath, archive string, hooks []string) error {
+	l := p.l
+	for _, hook := range hooks {
+		hook = ex.expand(hook)
+		if err := ex.check(l); nil != err {
+			return err
+		}
+		l.Infof("hook", "%s ...", hook)
+		l.Break()
+		cmd := hookCommand(hook)
+		cmd.Env = append(append(os.Environ(), p.sh.Environ()...),
+			hookPackageEnv+"="+pkgPath)
+		if archive != "" {
+			cmd.Env = append(cmd.Env, hookArchiveEnv+"="+archive)
+		}
+		out := l.Writer("hook")
+		cmd.Stdout, cmd.Stderr = out, out
+		err := cmd.Run()
+		out.Write([]byte{'\n'}) // flush output not terminated by newline
+		if nil != err {
+			err = HookFailedError(fmt.Sprintf("%s: %v", hook, err))
+		}
+		l.Infof("hook", "%s", hook)
+		l.Eolf("hook", err, " (ok)")
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookCommand returns the command that runs the given hook with the system
+// shell.
+func hookCommand(hook string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", hook)
+	}
+	return exec.Command("sh", "-c", hook)
+}
@@ -0,0 +1,56 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ardnew/svngrab/log"
+)
+
+// limitRate calls export, and then, if rate is positive, waits until the
+// average rate at which the tree rooted at path grew during the export does
+// not exceed rate bytes per second, logging the delay to l.
+// Neither svn nor git provides a means of limiting its own network bandwidth,
+// so the data retrieved is estimated by the growth of the working copy, and the
+// limit is enforced by delaying the next export rather than throttling each
+// read. The wait ends early if interrupt is closed.
+func limitRate(l *log.Log, rate int64, path string, interrupt <-chan struct{}, export func() error) error {
+	if rate <= 0 {
+		return export()
+	}
+	before := treeSize(path)
+	start := time.Now()
+	if err := export(); nil != err {
+		return err
+	}
+	grew := treeSize(path) - before
+	if grew <= 0 {
+		return nil
+	}
+	wait := time.Duration(float64(grew)/float64(rate)*float64(time.Second)) - time.Since(start)
+	if wait <= 0 {
+		return nil
+	}
+	wait = wait.Round(time.Millisecond)
+	l.Infof("rate", "%s: %d bytes retrieved, waiting %s (-limit %d bytes/s)", path, grew, wait, rate)
+	l.Break()
+	select {
+	case <-time.After(wait):
+	case <-interrupt:
+	}
+	return nil
+}
+
+// treeSize returns the total size of the regular files in the tree rooted at
+// path, ignoring any that cannot be read.
+func treeSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if nil == err && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,149 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ardnew/svngrab/config"
+
+	"github.com/otiai10/copy"
+)
+
+// linkTree creates, at dst, a link to each file in the tree rooted at src that
+// copy.Copy would copy with the given options, instead of copying it, by
+// calling link with the absolute path of the source file, the path of the link
+// to create, and the file's information. Any existing file at the path of the
+// link is removed first. Directories are created rather than linked, so that
+// content from several sources may be merged into the same package directory.
+// If dst is an existing directory, it is replaced, merged, or left untouched
+// according to opt.OnDirExists.
+func linkTree(src, dst string, opt copy.Options, link func(path, target string, info os.FileInfo) error) error {
+	abs, err := filepath.Abs(src)
+	if nil != err {
+		return err
+	}
+	if info, err := os.Lstat(dst); nil == err && info.IsDir() && nil != opt.OnDirExists {
+		switch opt.OnDirExists(src, dst) {
+		case copy.Untouchable:
+			return nil
+		case copy.Replace:
+			if err := os.RemoveAll(dst); nil != err {
+				return err
+			}
+		}
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		if path != src && nil != opt.Skip {
+			skip, err := opt.Skip(path)
+			if nil != err {
+				return err
+			}
+			if skip {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		rel, err := filepath.Rel(src, path)
+		if nil != err {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); nil != err {
+			return err
+		}
+		if err := os.Remove(target); nil != err && !os.IsNotExist(err) {
+			return err
+		}
+		return link(filepath.Join(abs, rel), target, info)
+	})
+}
+
+// symlinkFile creates a symbolic link at target referring to path. Symbolic
+// links in the source are linked like any other file.
+func symlinkFile(path, target string, info os.FileInfo) error {
+	return os.Symlink(path, target)
+}
+
+// hardlinkFunc returns a function that creates a hard link at target to the
+// regular file at path, for use with linkTree. A file that cannot be linked
+// (e.g., across devices) is copied instead, as is any other kind of file, with
+// the given options.
+func hardlinkFunc(opt copy.Options) func(path, target string, info os.FileInfo) error {
+	return func(path, target string, info os.FileInfo) error {
+		if info.Mode().IsRegular() {
+			if err := os.Link(path, target); nil == err {
+				return nil
+			}
+		}
+		return copy.Copy(path, target, opt)
+	}
+}
+
+// unlinkCopied returns a copy.Options Skip function that calls skip, and then
+// removes the existing destination file of each regular file to be copied
+// from src to dst (see unlinkFile). Files are thereby always copied to a new file, rather than
+// overwriting existing files in place, which may be hard links to a cache.
+func unlinkCopied(src, dst string, skip func(string) (bool, error)) func(string) (bool, error) {
+	return func(s string) (bool, error) {
+		if nil != skip {
+			if ok, err := skip(s); ok || nil != err {
+				return ok, err
+			}
+		}
+		if info, err := os.Lstat(s); nil != err || !info.Mode().IsRegular() {
+			return false, nil
+		}
+		rel, err := filepath.Rel(src, s)
+		if nil != err {
+			return false, err
+		}
+		return false, unlinkFile(filepath.Join(dst, rel))
+	}
+}
+
+// unlinkFile removes the given path if it is a regular file.
+func unlinkFile(path string) error {
+	if info, err := os.Lstat(path); nil == err && info.Mode().IsRegular() {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// hasLinks returns true if any copy operation of the given package links its
+// content instead of copying it (see linkTree).
+func hasLinks(pkg config.PackageConfig) bool {
+	for _, inc := range pkg.Include {
+		for _, list := range inc {
+			for _, op := range list {
+				if op.Copy.Symlink {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// hardlinks returns true if the given copy operation from src hard links its
+// files instead of copying them, which requires src to be within the
+// receiver's cache directory. Operations that modify the copied files (e.g.,
+// fileMode) would modify the cache and therefore always copy.
+func (p *packager) hardlinks(src string, cp config.IncludeCopyConfig) bool {
+	if p.cache == "" || cp.FileMode != "" || cp.PreserveOwner {
+		return false
+	}
+	cache, err := filepath.Abs(p.cache)
+	if nil != err {
+		return false
+	}
+	src, err = filepath.Abs(src)
+	return nil == err && within(src, cache)
+}
@@ -0,0 +1,54 @@
+// +build !windows
+
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCacheHardlinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		copy   string // flow mapping of the copy operation
+		linked bool   // package file is a hard link into the cache
+	}{
+		{"hard linked", "{repo: file.txt, package: file.txt}", true},
+		{"fileMode copied", "{repo: file.txt, package: file.txt, fileMode: \"0600\"}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := gitRepo(t, map[string]string{"file.txt": "content"})
+			dir := t.TempDir()
+			pkg := filepath.Join(dir, "package")
+			err := executeConfig(t, "cacheDir: "+filepath.Join(dir, "cache")+"\n"+
+				"export:\n"+
+				"  repo:\n"+
+				"    type: git\n"+
+				"    repo: "+remote+"\n"+
+				"    path: \"\"\n"+
+				"package:\n"+
+				"  "+pkg+":\n"+
+				"    include:\n"+
+				"      - repo:\n"+
+				"          - copy: "+tt.copy+"\n")
+			if nil != err {
+				t.Fatalf("Execute: %v", err)
+			}
+			dst := filepath.Join(pkg, "file.txt")
+			info, err := os.Stat(dst)
+			if nil != err {
+				t.Fatalf("%s: %v", dst, err)
+			}
+			stat, ok := info.Sys().(*syscall.Stat_t)
+			if !ok {
+				t.Skip("link count unavailable")
+			}
+			if linked := stat.Nlink > 1; linked != tt.linked {
+				t.Errorf("%s: link count %d, want hard link %t", dst, stat.Nlink, tt.linked)
+			}
+		})
+	}
+}
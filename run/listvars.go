@@ -0,0 +1,74 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+)
+
+// secretVariable matches the identifiers of variables whose values are masked
+// by ListVariables, unless Options.ShowSecrets is set.
+var secretVariable = regexp.MustCompile(`(?i)PASS|TOKEN|SECRET|KEY`)
+
+// secretReference matches a reference to a variable whose value is masked (see
+// secretVariable), such that a value referencing it is masked as well.
+var secretReference = regexp.MustCompile(`(?i)\$\{?[A-Za-z0-9_]*(PASS|TOKEN|SECRET|KEY)`)
+
+// ListVariables writes each variable defined for substitution in the
+// configuration file to w as an aligned table, with its source ("argument" for
+// user-defined variables of the given options, or "builtin") and its value,
+// expanded exactly as Execute would, without parsing the configuration file.
+// The environment is not listed, since any environment variable may be
+// referenced. The values of variables whose identifiers resemble secrets (e.g.,
+// $SVN_PASS or $API_TOKEN), or whose definitions reference such variables, are
+// masked unless Options.ShowSecrets is set. Builtin variables that cannot be
+// resolved (e.g., $HOST) are listed as undefined.
+// Returns the first variable substitution error, if any.
+func ListVariables(o Options, w io.Writer) error {
+
+	o = o.normalize()
+	l := o.Log
+
+	ex := newExpander(o.StrictVars, o.Variables)
+
+	// the raw definition of each variable, user-defined or builtin.
+	defs := make(map[string]string, len(Variable)+len(lazyVariable)+len(o.Variables))
+	for ident := range lazyVariable {
+		defs[ident] = ""
+	}
+	for ident, value := range Variable {
+		defs[ident] = value
+	}
+	for ident, value := range o.Variables {
+		defs[ident] = value
+	}
+	names := make([]string, 0, len(defs))
+	for ident := range defs {
+		names = append(names, ident)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSOURCE\tVALUE")
+	for _, ident := range names {
+		source := "builtin"
+		if _, ok := o.Variables[ident]; ok {
+			source = "argument"
+		}
+		value, ok := ex.lookup(ident[1:])
+		switch {
+		case !ok:
+			value = "(undefined)"
+		case !o.ShowSecrets && (secretVariable.MatchString(ident) ||
+			secretReference.MatchString(defs[ident])):
+			value = passwordMask
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", ident, source, value)
+	}
+	if err := tw.Flush(); nil != err {
+		return err
+	}
+	return ex.check(l)
+}
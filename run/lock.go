@@ -0,0 +1,62 @@
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ardnew/svngrab/log"
+)
+
+// lockSuffix is appended to the path of a configuration file to form the path
+// of the lock file held while it is executed.
+const lockSuffix = ".lock"
+
+// lockPollInterval is the delay between attempts to acquire a lock file that
+// is held by another process.
+const lockPollInterval = 250 * time.Millisecond
+
+// acquireLock creates the lock file at the given path, logging its progress,
+// and returns a function that removes it. If the lock file already exists, it
+// is retried until it is removed or the given timeout expires, in which case
+// AlreadyRunningError is returned.
+// The lock file contains the process ID of its holder. A lock file left behind
+// by a process that was killed must be removed manually.
+func acquireLock(l *log.Log, path string, timeout time.Duration) (func(), error) {
+	l.Infof("lock", "acquiring lock: %s ...", path)
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if nil == err {
+			_, err = fmt.Fprintln(f, os.Getpid())
+			if cerr := f.Close(); nil == err {
+				err = cerr
+			}
+			if nil != err {
+				os.Remove(path)
+			}
+		} else if os.IsExist(err) && !time.Now().Before(deadline) {
+			err = AlreadyRunningError(path + lockHolder(path))
+		} else if os.IsExist(err) {
+			time.Sleep(lockPollInterval)
+			continue
+		}
+		l.Eolf("lock", err, " (ok)")
+		if nil != err {
+			return nil, err
+		}
+		return func() { os.Remove(path) }, nil
+	}
+}
+
+// lockHolder returns a description of the process ID recorded in the lock file
+// at the given path, or the empty string if it cannot be read.
+func lockHolder(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if pid := strings.TrimSpace(string(b)); nil == err && pid != "" {
+		return " (pid " + pid + ")"
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ardnew/svngrab/log"
+	"github.com/ardnew/svngrab/repo"
+
+	"github.com/otiai10/copy"
+)
+
+// mirrorRepo replaces the directory at path mirror with a copy of the working
+// copy of the given repository, excluding VCS metadata, logging its progress
+// to l. Returns MirrorFailedError if the mirror could not be replaced.
+func mirrorRepo(l *log.Log, rep *repo.Repo, mirror string) error {
+	l.Infof("mirr", "%s -> %s ...", rep.LocalPath(), mirror)
+	err := replaceMirror(rep.LocalPath(), mirror)
+	l.Eolf("mirr", err, " (ok)")
+	return err
+}
+
+// replaceMirror removes the directory at path mirror and copies the directory
+// at path local in its place. The two paths must not contain each other.
+func replaceMirror(local, mirror string) error {
+	src, err := filepath.Abs(local)
+	if nil != err {
+		return MirrorFailedError(err.Error())
+	}
+	dst, err := filepath.Abs(mirror)
+	if nil != err {
+		return MirrorFailedError(err.Error())
+	}
+	if within(src, dst) || within(dst, src) {
+		return MirrorFailedError(mirror + ": overlaps working copy " + local)
+	}
+	if err := os.RemoveAll(dst); nil != err {
+		return MirrorFailedError(err.Error())
+	}
+	err = copy.Copy(src, dst, copy.Options{
+		OnSymlink: func(s string) copy.SymlinkAction { return copy.Shallow },
+		Skip:      func(s string) (bool, error) { return isVcsMeta(s), nil },
+	})
+	if nil != err {
+		return MirrorFailedError(err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package run
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ardnew/svngrab/config"
+	"github.com/ardnew/svngrab/log"
+)
+
+// ForceCheckout selects when an existing working copy is discarded and checked
+// out anew, rather than updated.
+type ForceCheckout int
+
+// Constant values of enumerated type ForceCheckout.
+const (
+	NeverForceCheckout     ForceCheckout = iota // always update
+	ForceCheckoutOnFailure                      // discard if the update fails
+	AlwaysForceCheckout                         // always discard
+)
+
+// Options configures a single execution of Execute or Status.
+// The zero value of each field selects its default behavior.
+type Options struct {
+	// Log receives the progress of the execution. If nil, progress is discarded.
+	Log *log.Log
+	// ConfigPath is the path of the configuration file, or config.Stdin.
+	ConfigPath string
+	// OutputPath is the path to which the configuration is written with the
+	// updated repository revisions. If empty, ConfigPath is used.
+	OutputPath string
+	// MakeParents creates the directory of the output path, along with any
+	// missing parents, before writing the configuration.
+	MakeParents bool
+	// NoWrite skips writing the configuration with the updated repository
+	// revisions, so that the next execution exports the same changes again.
+	NoWrite bool
+	// Env receives the shell environment script describing the results. If nil,
+	// the script is discarded. Env is closed when the execution completes.
+	Env *ShellEnv
+	// Variables contains user-defined variable values, keyed by identifier
+	// (with or without its leading "$"). These override builtin variables.
+	Variables map[string]string
+	// Update returns WorkingCopiesUpToDate(true) without packaging anything if
+	// no repository has changed since its last export.
+	Update bool
+	// RequireUpdate lists the names of repositories that must be updated by the
+	// execution. If any is unchanged since its last export, RepositoryUnchanged
+	// is returned without packaging anything.
+	RequireUpdate []string
+	// Only lists the names of the repositories and packages to process. Each
+	// repository included by a listed package is also processed. If empty,
+	// all repositories and packages are processed.
+	Only []string
+	// Except lists the names of the repositories and packages not to process.
+	// A package cannot be processed without each of its repositories.
+	Except []string
+	// SinceLast builds only the packages that include a repository updated by
+	// the execution, or that include no repository at all. A package whose
+	// build failed is therefore not rebuilt until its repositories change
+	// again, unless Force is set.
+	SinceLast bool
+	// Force builds every package, even with SinceLast.
+	Force bool
+	// ChangedPaths records the paths changed in each exported repository since
+	// its last export in the returned Result (see Result.WriteChanges).
+	ChangedPaths bool
+	// StrictVars fails the execution if a variable reference is undefined.
+	StrictVars bool
+	// KeepGoing continues after failed copy and archive operations, returning
+	// all such errors together as a MultiError.
+	KeepGoing bool
+	// AllowAbsoluteDest permits copy operations to absolute package paths,
+	// which may be outside of the package. Otherwise, they fail with
+	// UnsafeDestinationError, as do relative paths outside of the package.
+	AllowAbsoluteDest bool
+	// Dereference archives the files referred to by symbolic links in each
+	// package, rather than the links themselves. Otherwise, packages containing
+	// links to their sources (see config.IncludeCopyConfig) are not archived.
+	Dereference bool
+	// ForceCheckout discards existing working copies, either always or only
+	// those that fail to update, and checks them out anew. Working copies of
+	// clean exports (see config.ExportConfig) are always replaced anyway.
+	ForceCheckout ForceCheckout
+	// Verbose logs the progress output of each SVN export.
+	Verbose bool
+	// Jobs is the maximum number of repositories exported, and of packages
+	// built, concurrently (default 1).
+	Jobs int
+	// Limit is the maximum average rate, in bytes per second, at which data is
+	// retrieved by exports, which are then run one at a time regardless of Jobs.
+	// Each export is followed by a delay long enough that the growth of its
+	// working copy does not exceed the rate (see limitRate). If zero, exports
+	// are not limited.
+	Limit int64
+	// Retries is the number of times failed network operations are retried.
+	Retries int
+	// LockTimeout is the maximum duration to wait for another execution of the
+	// same configuration file to finish. If zero, AlreadyRunningError is
+	// returned immediately if another execution holds its lock.
+	LockTimeout time.Duration
+	// Interrupt is closed to stop the execution gracefully (e.g., on SIGINT).
+	// No further exports or packages are started, the revisions of completed
+	// exports are recorded, and InterruptedError is returned. If nil, the
+	// execution is never interrupted.
+	Interrupt <-chan struct{}
+	// Timeout is the maximum duration of each network operation, including all
+	// of its retries. If zero, operations are never timed out.
+	Timeout time.Duration
+	// ShowSecrets prints passwords (see PrintConfig) and the values of
+	// variables resembling secrets (see ListVariables) rather than masking them.
+	ShowSecrets bool
+	// Svn overrides the svn command configured by the configuration file (see
+	// config.SvnConfig): a non-empty Path replaces the configured executable,
+	// and Args are passed to svn after the configured arguments.
+	Svn config.SvnConfig
+}
+
+// normalize returns a copy of the receiver with the default value of each
+// unset field assigned, and with the identifier of each user-defined variable
+// prefixed with "$".
+func (o Options) normalize() Options {
+	if nil == o.Log {
+		o.Log = log.New(io.Discard)
+	}
+	if nil == o.Env {
+		o.Env = NewShellEnv("<bitbucket>", ShDialect, io.Discard, nil)
+	}
+	if o.Jobs < 1 {
+		o.Jobs = 1
+	}
+	vars := make(map[string]string, len(o.Variables))
+	for ident, value := range o.Variables {
+		if !strings.HasPrefix(ident, "$") {
+			ident = "$" + ident
+		}
+		vars[ident] = value
+	}
+	o.Variables = vars
+	return o
+}
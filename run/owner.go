@@ -0,0 +1,17 @@
+// +build !windows
+
+package run
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the user and group IDs of the owner of the given file, and
+// true if they are known.
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid), true
+	}
+	return 0, 0, false
+}
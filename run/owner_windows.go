@@ -0,0 +1,10 @@
+// +build windows
+
+package run
+
+import "os"
+
+// fileOwner returns false, since file ownership is not supported on Windows.
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,70 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// chmodTree sets the permissions of every directory and regular file in the
+// tree rooted at the given path to dirMode and fileMode, respectively. A zero
+// mode leaves permissions of that kind unmodified. Symbolic links are never
+// followed or modified.
+// Directories are modified only after the entire tree has been walked, so that
+// a restrictive dirMode cannot prevent their traversal.
+func chmodTree(root string, dirMode, fileMode os.FileMode) error {
+	if dirMode == 0 && fileMode == 0 {
+		return nil
+	}
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			if dirMode != 0 {
+				dirs = append(dirs, path)
+			}
+		case info.Mode().IsRegular():
+			if fileMode != 0 {
+				return os.Chmod(path, fileMode)
+			}
+		}
+		return nil
+	})
+	if nil != err {
+		return err
+	}
+	// modify the deepest directories first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], dirMode); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// chownTree sets the owner and group of every file and directory in the tree
+// rooted at dst to those of the file at the same relative path in the tree
+// rooted at src. Files without a corresponding source file are unmodified, and
+// symbolic links themselves are modified rather than their targets.
+// It is a no-op on systems that do not report file ownership (e.g., Windows).
+func chownTree(src, dst string) error {
+	return filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if nil != err {
+			return err
+		}
+		sinfo, err := os.Lstat(filepath.Join(src, rel))
+		if nil != err {
+			return nil // not copied from src
+		}
+		if uid, gid, ok := fileOwner(sinfo); ok {
+			return os.Lchown(path, uid, gid)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,72 @@
+package run
+
+import (
+	"io"
+
+	"github.com/ardnew/svngrab/config"
+)
+
+// passwordMask replaces each non-empty password in a printed configuration, and
+// the value of each secret variable listed (see ListVariables).
+const passwordMask = "********"
+
+// PrintConfig writes the configuration file of the given options to w as YAML,
+// with variable substitution performed on every field exactly as Execute
+// would, without exporting or packaging anything. Included configuration files
+// are merged, only the selected repositories and packages are written (see
+// Options.Only), and passwords are masked (unless Options.ShowSecrets is set).
+// Since the revisions of each repository are known only after export, each
+// $REPO_<name>_PREVREV variable is replaced with the revision recorded by its
+// last export, and each $REPO_<name>_CURRREV variable is left as-is.
+func PrintConfig(o Options, w io.Writer) error {
+
+	o = o.normalize()
+	l := o.Log
+
+	cfg, err := parseConfig(l, o.ConfigPath)
+	if nil != err {
+		return err
+	}
+
+	ex := newExpander(o.StrictVars, o.Variables)
+
+	exports, packages, err := selectConfig(l, cfg, ex, o.Only, o.Except)
+	if nil != err {
+		return err
+	}
+
+	out := &config.Config{
+		CacheDir: ex.expand(cfg.CacheDir),
+		Svn:      svnCommand(ex, cfg, o),
+		Export:   config.ExportMap{},
+		Package:  config.PackageMap{},
+	}
+	revs := map[string]string{}
+	for key, expo := range exports {
+		name, expo := expandExport(ex, out.CacheDir, key, expo)
+		if expo.Password != "" && !o.ShowSecrets {
+			expo.Password = passwordMask
+		}
+		out.Export[name] = expo
+		revs["$REPO_"+name+"_PREVREV"] = expo.Last
+		revs["$REPO_"+name+"_CURRREV"] = `\$REPO_` + name + "_CURRREV"
+	}
+	if err := ex.check(l); nil != err {
+		return err
+	}
+
+	px := ex.within(revs)
+	for key, pkg := range packages {
+		out.Package[px.expand(key)] = pkg.Expand(px.expand)
+	}
+	if err := px.check(l); nil != err {
+		return err
+	}
+
+	data, err := out.Marshal()
+	if nil != err {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
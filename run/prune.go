@@ -0,0 +1,54 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// prune removes all content of the given destination paths that is not in the
+// given set of paths copied into the package, logging its progress.
+func (p *packager) prune(roots []string, keep map[string]bool) error {
+	sort.Strings(roots)
+	for i, root := range roots {
+		if i > 0 && root == roots[i-1] {
+			continue
+		}
+		p.l.Infof("prun", "%s ...", root)
+		n, err := pruneTree(root, keep)
+		p.l.Eolf("prun", err, " (%d removed)", n)
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneTree removes each file and directory in the tree rooted at the given
+// path, excluding the root itself, that is not in the given set of paths, and
+// returns the number removed. A directory that is removed is removed with all
+// of its content. Symbolic links are removed rather than followed.
+func pruneTree(root string, keep map[string]bool) (int, error) {
+	root = filepath.Clean(root)
+	removed := 0
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if path == root || keep[path] {
+			return nil
+		}
+		if err := os.RemoveAll(path); nil != err {
+			return err
+		}
+		removed++
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,30 @@
+package run
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// reproducibleEpoch returns the modification time assigned to every entry of
+// a reproducible archive, which is given by the SOURCE_DATE_EPOCH environment
+// variable (seconds since the Unix epoch) if defined, or else the earliest
+// time representable in all supported formats (1980-01-01 00:00:00 UTC).
+func reproducibleEpoch() time.Time {
+	if sec, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); nil == err {
+		return time.Unix(sec, 0).UTC()
+	}
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// fixedFileInfo is an os.FileInfo with a custom name and modification time,
+// and without any system-specific metadata (e.g., owner and access time).
+type fixedFileInfo struct {
+	os.FileInfo
+	name    string
+	modTime time.Time
+}
+
+func (fi fixedFileInfo) Name() string       { return fi.name }
+func (fi fixedFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fixedFileInfo) Sys() interface{}   { return nil }
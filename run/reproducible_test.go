@@ -0,0 +1,71 @@
+package run
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reproducibleTree writes the same files to a new directory, in forward or
+// reverse order, and sets the modification time of every file and directory
+// to the given time. Returns the directory path.
+func reproducibleTree(t *testing.T, reverse bool, mtime time.Time) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := []string{"a.txt", "b/c.txt", "b/d/e.txt", "f/g.txt"}
+	if reverse {
+		for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+			files[i], files[j] = files[j], files[i]
+		}
+	}
+	for _, name := range files {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), "content of "+name)
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		return os.Chtimes(path, mtime, mtime)
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReproducibleArchive(t *testing.T) {
+	for _, method := range []string{"zip", "tar", "tar.gz"} {
+		t.Run(method, func(t *testing.T) {
+			var archive [2][]byte
+			for i, mtime := range []time.Time{
+				time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC),
+				time.Date(2022, 11, 12, 13, 14, 15, 0, time.UTC),
+			} {
+				src := reproducibleTree(t, i > 0, mtime)
+				out := t.TempDir()
+				arc := filepath.Join(out, "pkg."+method)
+				err := executeConfig(t, "package:\n"+
+					"  "+filepath.Join(out, "pkg")+":\n"+
+					"    include:\n"+
+					"      - "+src+":\n"+
+					"          - copy: {repo: ., package: .}\n"+
+					"    compress:\n"+
+					"      output: "+arc+"\n"+
+					"      method: "+method+"\n"+
+					"      reproducible: true\n")
+				if nil != err {
+					t.Fatalf("Execute: %v", err)
+				}
+				if archive[i], err = ioutil.ReadFile(arc); nil != err {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(archive[0], archive[1]) {
+				t.Errorf("archives differ (%d and %d bytes)", len(archive[0]), len(archive[1]))
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package run
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rosterName is the name of the roster file written into a package.
+const rosterName = "ROSTER.tsv"
+
+// rosterSource identifies the repository from which content was copied to a
+// destination path in a package.
+type rosterSource struct {
+	dst  string // destination path of the copy
+	repo string // name of the repository, or empty if not a repository
+}
+
+// writeRoster writes a file into the root of the given package path listing
+// every file in the package, logging its progress.
+// The roster is a tab-separated table with a header line, and one line per
+// file (sorted by path) with the following fields:
+//
+//	path       path relative to the package root, with "/" separators
+//	size       size in bytes
+//	repo       name of the repository from which the file was copied
+//	revision   revision of that repository exported by this run
+//
+// The repository of a file is that of the last copy operation whose
+// destination contains it. The repo and revision fields are "-" for files not
+// copied from an exported repository (e.g., the changelog).
+func (p *packager) writeRoster(pkgPath string, sources []rosterSource) error {
+
+	l := p.l
+
+	rosterPath := filepath.Join(pkgPath, rosterName)
+	l.Infof("rost", "writing roster: %s ...", rosterPath)
+	var (
+		roster string
+		count  int
+	)
+	err := os.MkdirAll(pkgPath, 0755)
+	if nil == err {
+		roster, count, err = p.roster(pkgPath, rosterPath, sources)
+	}
+	if nil == err {
+		err = ioutil.WriteFile(rosterPath, []byte(roster), 0644)
+	}
+	l.Eolf("rost", err, " (%d files)", count)
+	return err
+}
+
+// roster returns the content of the roster of the given package path (see
+// writeRoster), excluding the roster file itself, and the number of files it
+// lists.
+func (p *packager) roster(pkgPath, rosterPath string, sources []rosterSource) (string, int, error) {
+	var sb strings.Builder
+	sb.WriteString("path\tsize\trepo\trevision\n")
+	count := 0
+	err := filepath.Walk(pkgPath, func(path string, info os.FileInfo, err error) error {
+		if nil != err || info.IsDir() || path == rosterPath {
+			return err
+		}
+		rel, err := filepath.Rel(pkgPath, path)
+		if nil != err {
+			return err
+		}
+		repo, rev := "-", "-"
+		for i := len(sources) - 1; i >= 0; i-- {
+			if within(path, sources[i].dst) {
+				if name := sources[i].repo; name != "" {
+					repo = name
+					if r, ok := p.revs[name]; ok && r.to != "" {
+						rev = r.to
+					}
+				}
+				break
+			}
+		}
+		fmt.Fprintf(&sb, "%s\t%d\t%s\t%s\n", filepath.ToSlash(rel), info.Size(), repo, rev)
+		count++
+		return nil
+	})
+	return sb.String(), count, err
+}
+
+// within returns true if and only if the given path is equal to or contained
+// in the given directory path.
+func within(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -1,10 +1,18 @@
 package run
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
 	"io"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ardnew/svngrab/config"
@@ -17,9 +25,20 @@ import (
 
 // Type definitions for various errors raised by run package.
 type (
-	InvalidIgnorePattern  string
-	InvalidCompressMethod string
-	WorkingCopiesUpToDate bool
+	InvalidIgnorePattern   string
+	InvalidCompressMethod  string
+	InvalidCompressLevel   string
+	InvalidChecksumAlgo    string
+	InvalidShellDialect    string
+	HookFailedError        string
+	MirrorFailedError      string
+	AlreadyRunningError    string
+	UnsafeDestinationError string
+	IncompleteArchiveError string
+	InvalidSelectorError   string
+	InterruptedError       string
+	RepositoryUnchanged    string
+	WorkingCopiesUpToDate  bool
 )
 
 // Error returns the string representation of InvalidIgnorePattern
@@ -27,61 +46,252 @@ func (e InvalidIgnorePattern) Error() string {
 	return "invalid ignore pattern: " + string(e)
 }
 
+// Type returns the type name of InvalidIgnorePattern
+func (e InvalidIgnorePattern) Type() string {
+	return "InvalidIgnorePattern"
+}
+
 // Error returns the string representation of InvalidCompressMethod
 func (e InvalidCompressMethod) Error() string {
 	return "invalid compress method: " + string(e)
 }
 
+// Type returns the type name of InvalidCompressMethod
+func (e InvalidCompressMethod) Type() string {
+	return "InvalidCompressMethod"
+}
+
+// Error returns the string representation of InvalidCompressLevel
+func (e InvalidCompressLevel) Error() string {
+	return "invalid compress level: " + string(e)
+}
+
+// Type returns the type name of InvalidCompressLevel
+func (e InvalidCompressLevel) Type() string {
+	return "InvalidCompressLevel"
+}
+
+// Error returns the string representation of InvalidChecksumAlgo
+func (e InvalidChecksumAlgo) Error() string {
+	return "invalid checksum algorithm: " + string(e)
+}
+
+// Type returns the type name of InvalidChecksumAlgo
+func (e InvalidChecksumAlgo) Type() string {
+	return "InvalidChecksumAlgo"
+}
+
+// Error returns the string representation of InvalidShellDialect
+func (e InvalidShellDialect) Error() string {
+	return "invalid shell dialect: " + string(e)
+}
+
+// Type returns the type name of InvalidShellDialect
+func (e InvalidShellDialect) Type() string {
+	return "InvalidShellDialect"
+}
+
+// MultiError contains every error encountered by operations that continued
+// after failure (i.e., in keep-going mode).
+type MultiError []error
+
+// Error returns the string representation of MultiError
+func (e MultiError) Error() string {
+	msg := make([]string, len(e))
+	for i, err := range e {
+		msg[i] = err.Error()
+	}
+	return fmt.Sprintf("%d operation(s) failed: %s", len(e), strings.Join(msg, "; "))
+}
+
+// Type returns the type name of MultiError
+func (e MultiError) Type() string {
+	return "MultiError"
+}
+
+// Error returns the string representation of HookFailedError
+func (e HookFailedError) Error() string {
+	return "hook command failed: " + string(e)
+}
+
+// Type returns the type name of HookFailedError
+func (e HookFailedError) Type() string {
+	return "HookFailedError"
+}
+
+// Error returns the string representation of AlreadyRunningError
+func (e AlreadyRunningError) Error() string {
+	return "already running, lock held: " + string(e)
+}
+
+// Type returns the type name of AlreadyRunningError
+func (e AlreadyRunningError) Type() string {
+	return "AlreadyRunningError"
+}
+
+// Error returns the string representation of UnsafeDestinationError
+func (e UnsafeDestinationError) Error() string {
+	return "unsafe copy destination: " + string(e)
+}
+
+// Type returns the type name of UnsafeDestinationError
+func (e UnsafeDestinationError) Type() string {
+	return "UnsafeDestinationError"
+}
+
+// Error returns the string representation of IncompleteArchiveError
+func (e IncompleteArchiveError) Error() string {
+	return "incomplete archive: " + string(e)
+}
+
+// Type returns the type name of IncompleteArchiveError
+func (e IncompleteArchiveError) Type() string {
+	return "IncompleteArchiveError"
+}
+
+// Error returns the string representation of InvalidSelectorError
+func (e InvalidSelectorError) Error() string {
+	return "invalid selection: " + string(e)
+}
+
+// Type returns the type name of InvalidSelectorError
+func (e InvalidSelectorError) Type() string {
+	return "InvalidSelectorError"
+}
+
+// Error returns the string representation of InterruptedError
+func (e InterruptedError) Error() string {
+	return "interrupted: " + string(e)
+}
+
+// Type returns the type name of InterruptedError
+func (e InterruptedError) Type() string {
+	return "InterruptedError"
+}
+
+// Error returns the string representation of MirrorFailedError
+func (e MirrorFailedError) Error() string {
+	return "mirror failed: " + string(e)
+}
+
+// Type returns the type name of MirrorFailedError
+func (e MirrorFailedError) Type() string {
+	return "MirrorFailedError"
+}
+
+// Error returns the string representation of RepositoryUnchanged
+func (e RepositoryUnchanged) Error() string {
+	return "required repository not updated: " + string(e)
+}
+
+// Type returns the type name of RepositoryUnchanged
+func (e RepositoryUnchanged) Type() string {
+	return "RepositoryUnchanged"
+}
+
 // Error returns the string representation of WorkingCopiesUpToDate
 func (e WorkingCopiesUpToDate) Error() string {
 	return "all working copies up-to-date"
 }
 
+// Type returns the type name of WorkingCopiesUpToDate
+func (e WorkingCopiesUpToDate) Type() string {
+	return "WorkingCopiesUpToDate"
+}
+
 // Constants defining default behaviors for file copy operations.
 const (
 	DefaultSymlinkAction   = copy.Skip
 	DefaultDirExistsAction = copy.Merge
 )
 
-var Variable = map[string]string{
-	//	"$DATE":     time.Now().Local().Format("20060102"),
-	"$DATETIME": time.Now().Local().Format("20060102-150405"),
+// Execute executes the main program logic with the given options, and returns
+// the results of the repositories exported and packages built.
+// The updated repository revisions are written to the output path, or to the
+// configuration file if the output path is empty.
+// The results are returned even if an error occurs, in which case they are
+// incomplete. The results are also logged as a table (see Result.WriteTable)
+// once the execution completes, unless the log is quiet.
+func Execute(o Options) (Result, error) {
+	o = o.normalize()
+	sum := newSummary()
+	err := execute(o, sum)
+	res := sum.result()
+	if len(res.Repos) > 0 || len(res.Packages) > 0 {
+		res.WriteTable(o.Log.Writer("summ"))
+	}
+	return res, err
 }
 
-// Run executes the main program logic using the given log and configuration
-// file path.
-func Run(l *log.Log, path string, sh *ShellEnv, update bool, vars map[string]string) error {
+// execute implements Execute with the given normalized options, recording its
+// results in sum.
+func execute(o Options, sum *summary) error {
+
+	l, sh := o.Log, o.Env
 
 	// store each of our key-value string pairs to be written into our shell
 	// environment script.
 	defer sh.Close()
 
-	// copy the user variables definitions into our variable map.
-	for ident, value := range vars {
-		Variable[ident] = value
-		sh.Append("input variables", "VAR_"+ident, value)
+	// hold a lock beside the configuration file for the entire execution, so
+	// that concurrent executions cannot corrupt the working copies or race to
+	// write the repository revisions.
+	if o.ConfigPath != config.Stdin {
+		unlock, err := acquireLock(l, o.ConfigPath+lockSuffix, o.LockTimeout)
+		if nil != err {
+			return err
+		}
+		defer unlock()
 	}
 
 	// parse the configuration file if it is valid YAML format.
-	l.Infof("conf", "parsing configuration file: %s ...", path)
-	cfg, err := config.Parse(path)
-	l.Eolf("conf", err, " (ok)")
+	cfg, err := parseConfig(l, o.ConfigPath)
 	if nil != err {
 		return err
 	}
 
-	// create a mapping of export identifiers to actual VCS repository objects.
+	// perform variable substitution on the configuration, falling back on the
+	// environment for undefined variables.
+	ex := newExpander(o.StrictVars, o.Variables)
+
+	// export the user variables, expanded, except for those referenced by any
+	// password field, which must never appear in the shell environment.
+	for ident := range o.Variables {
+		if !isSecret(cfg, ident) {
+			sh.Append("input variables", "VAR_"+ident, ex.value(ident))
+		}
+	}
+	if err := ex.check(l); nil != err {
+		return err
+	}
+	flushEnv(l, sh, "input variables")
+
+	// select only the repositories and packages requested, if any. the other
+	// repositories are neither exported nor have their revisions changed.
+	exports, packages, err := selectConfig(l, cfg, ex, o.Only, o.Except)
+	if nil != err {
+		return err
+	}
+
+	// working copies of exports without a local path are kept in the cache.
+	cacheDir := ex.expand(cfg.CacheDir)
+	svn := svnCommand(ex, cfg, o)
+
+	// create a mapping of export identifiers to actual VCS repository objects,
+	// to the revision recorded by their last export, to their mirror, and to
+	// their key in the configuration (i.e., before variable substitution).
 	reps := map[string]*repo.Repo{}
+	last := map[string]string{}
+	mirrors := map[string]string{}
+	keys := map[string]string{}
 
 	// verify we can connect to each of the repository objects.
-	for name, expo := range cfg.Export {
+	for key, expo := range exports {
 
 		// perform string replacement with variables on the name and export fields.
-		for ident, value := range Variable {
-			name = strings.ReplaceAll(name, ident, value)
-			expo.Repo = strings.ReplaceAll(expo.Repo, ident, value)
-			expo.Path = strings.ReplaceAll(expo.Path, ident, value)
-			expo.Local = strings.ReplaceAll(expo.Local, ident, value)
+		name, expo := expandExport(ex, cacheDir, key, expo)
+		if err := ex.check(l); nil != err {
+			return err
 		}
 
 		sh.Append(name, "REPO_"+name+"_URL",
@@ -92,16 +302,7 @@ func Run(l *log.Log, path string, sh *ShellEnv, update bool, vars map[string]str
 		sh.Append(name, "REPO_"+name+"_PREVREV", "")
 		sh.Append(name, "REPO_"+name+"_CURRREV", "")
 
-		l.Infof("repo", "initializing repostiory: %s ...", name)
-		rep, err := repo.New(expo)
-		l.Eolf("repo", err, " (ok)")
-		if nil != err {
-			return err
-		}
-
-		l.Infof("ping", "checking repository status: %s ...", name)
-		_, err = rep.IsConnected()
-		l.Eolf("ping", err, " (online)")
+		rep, err := openRepo(l, name, expo, svn, o.Retries, o.Timeout)
 		if nil != err {
 			return err
 		}
@@ -109,189 +310,1279 @@ func Run(l *log.Log, path string, sh *ShellEnv, update bool, vars map[string]str
 		// install the repository reference in our map so that it can be referenced
 		// in the package rules.
 		reps[name] = rep
+		last[name] = expo.Last
+		keys[name] = key
+		if expo.Mirror != "" {
+			mirrors[name] = expo.Mirror
+		}
 	}
 
-	didUpdate := false
-	// export each of the repositories to a local working directory.
-	for name, rep := range reps {
-		var vers string
-		mode, _ := rep.Exporter()
-		l.Infof(mode.String(), "%s -> %s", rep.Remote(), rep.LocalPath())
-		err := rep.Export()
+	// if user provided update flag -u, first compare the revision of each remote
+	// repository with its recorded revision, so that we may return early without
+	// exporting anything. if any remote revision cannot be determined, we fall
+	// back on exporting all repositories and comparing their revisions.
+	if o.Update && remoteUpToDate(l, reps, last) {
+		for name, rev := range last {
+			appendRevs(sh, name, rev, rev)
+			sum.addRepo(name, rev, rev)
+		}
+		upToDate := WorkingCopiesUpToDate(true)
+		if err := commitEnv(l, sh); nil != err {
+			return err
+		}
+		l.Errorf("conf", "%s", upToDate)
+		l.Break()
+		return upToDate
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex // guards l, cfg.Export, revs, didUpdate, and exportErr
+		revs      = map[string]revRange{}
+		didUpdate bool
+		exportErr error
+	)
+
+	// export each of the repositories to a local working directory, using a pool
+	// of up to jobs concurrent workers. each worker buffers its log output and
+	// flushes it all at once so that lines from concurrent exports do not
+	// interleave. once any export fails, no further exports are started.
+	// exports are limited to one at a time when their rate is limited.
+	jobs := o.Jobs
+	if o.Limit > 0 {
+		jobs = 1
+	}
+	names := make(chan string)
+	abort := make(chan struct{})
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				select {
+				case <-abort:
+					continue
+				case <-o.Interrupt:
+					continue
+				default:
+				}
+				var buf bytes.Buffer
+				el := l.Redirect(&buf)
+				if jobs == 1 {
+					// nothing can interleave with a single worker, so log directly to
+					// show verbose progress as it happens.
+					el = l
+				}
+				var vers string
+				err := limitRate(el, o.Limit, reps[name].LocalPath(), o.Interrupt, func() (err error) {
+					vers, err = exportRepo(el, reps[name], o.Retries, o.Verbose, o.ForceCheckout)
+					return err
+				})
+				mu.Lock()
+				l.Putf("%s", buf.String())
+				if nil != err {
+					if nil == exportErr {
+						exportErr = err
+						close(abort)
+					}
+				} else if expo, ok := cfg.Export[keys[name]]; ok {
+					// update the last revision in the Config struct, keyed by the name
+					// as written in the configuration, so that it is written back to
+					// the same export even if its name references variables.
+					if expo.Last != vers {
+						didUpdate = true
+					}
+					appendRevs(sh, name, expo.Last, vers)
+					flushEnv(l, sh, name)
+					sum.addRepo(name, expo.Last, vers)
+					revs[name] = revRange{from: expo.Last, to: vers}
+					expo.Last = vers
+					cfg.Export[keys[name]] = expo
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for name := range reps {
+		if interrupted(o.Interrupt) {
+			break
+		}
+		select {
+		case names <- name:
+		case <-abort:
+			break dispatch
+		case <-o.Interrupt:
+			break dispatch
+		}
+	}
+	close(names)
+	wg.Wait()
+
+	// once interrupted, record the revisions of the exports already completed,
+	// so that they are not repeated by the next run, and stop. exports that
+	// failed due to the interrupt (e.g., killed by the same signal) are ignored.
+	if interrupted(o.Interrupt) {
+		err := writeConfig(l, cfg, o)
+		if cerr := commitEnv(l, sh); nil == err {
+			err = cerr
+		}
 		if nil == err {
-			vers, err = rep.Revision()
+			err = InterruptedError("revisions of completed exports recorded")
+			l.Errorf("intr", "%s", err)
+			l.Break()
+		}
+		return err
+	}
+	if nil != exportErr {
+		return exportErr
+	}
+
+	// copy each exported working copy to its mirror. mirrors are best-effort in
+	// keep-going mode, where failures are reported after packaging.
+	var errs MultiError
+	mirrored := make([]string, 0, len(mirrors))
+	for name := range mirrors {
+		mirrored = append(mirrored, name)
+	}
+	sort.Strings(mirrored)
+	for _, name := range mirrored {
+		if err := mirrorRepo(l, reps[name], mirrors[name]); nil != err {
+			if !o.KeepGoing {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	// list the paths changed in each exported repository, if requested.
+	if o.ChangedPaths {
+		if err := listChanges(l, sum, reps, revs); nil != err {
+			if !o.KeepGoing {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	// fail if any repository required to be updated was not, without writing
+	// the configuration, so that its changes are still detected by the next run.
+	if err := requireUpdate(o.RequireUpdate, reps, revs); nil != err {
+		if cerr := commitEnv(l, sh); nil != cerr {
+			return cerr
+		}
+		l.Errorf("conf", "%s", err)
+		l.Break()
+		return err
+	}
+
+	// return early if user provided update flag -u and we did not update
+	// any working copy.
+	if upToDate := WorkingCopiesUpToDate(o.Update && !didUpdate); upToDate {
+		if err := commitEnv(l, sh); nil != err {
+			return err
+		}
+		l.Errorf("conf", "%s", upToDate)
+		l.Break()
+		return upToDate
+	}
+
+	// record the updated revisions of each repository.
+	if err := writeConfig(l, cfg, o); nil != err {
+		return err
+	}
+
+	// walk over each declared output package. the shell environment is generated
+	// afterward, even if packaging fails, so that it includes package results.
+	// every field of each package may reference the revisions of each exported
+	// repository, which are known only now.
+	pk := &packager{l: l, sh: sh, sum: sum, reps: reps, revs: revs,
+		keep: o.KeepGoing, allowAbs: o.AllowAbsoluteDest, deref: o.Dereference, cache: cacheDir,
+		interrupt: o.Interrupt}
+	pk.ex = ex.within(pk.revisionVars())
+	if o.SinceLast && !o.Force {
+		packages = changedPackages(l, pk.ex, packages, revs)
+	}
+	err = pk.makePackages(packages, o.Jobs, &errs)
+	if nil == err && len(errs) > 0 {
+		err = errs
+	}
+	if cerr := commitEnv(l, sh); nil == err {
+		err = cerr
+	}
+	return err
+}
+
+// writeConfig writes the given configuration, with the updated repository
+// revisions, to the output path of the given options, logging its progress.
+// The configuration cannot be written back to stdin, so the revisions are only
+// recorded if an output path is given, and never if NoWrite is set.
+func writeConfig(l *log.Log, cfg *config.Config, o Options) error {
+	outPath := o.OutputPath
+	if outPath == "" {
+		outPath = o.ConfigPath
+	}
+	if o.NoWrite {
+		l.Warnf("conf", "repository revisions not written: %s", outPath)
+		l.Break()
+	} else if outPath == config.Stdin {
+		l.Warnf("conf", "configuration read from stdin, repository revisions not written")
+		l.Break()
+	} else {
+		l.Infof("conf", "writing repository revisions: %s ...", outPath)
+		var err error
+		if o.MakeParents {
+			err = config.MakeDir(outPath)
+		}
+		if nil == err {
+			err = cfg.WriteFile(outPath)
+		}
+		l.Eolf("conf", err, " (ok)")
+		return err
+	}
+	return nil
+}
+
+// interrupted returns true if and only if the given channel is closed.
+// A nil channel is never closed.
+func interrupted(interrupt <-chan struct{}) bool {
+	select {
+	case <-interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// commitEnv writes the given shell environment, logging its progress to l,
+// along with each key that is renamed as an identifier beyond a change of case,
+// and each collision of identifiers.
+func commitEnv(l *log.Log, sh *ShellEnv) error {
+	ident := sh.Identifiers()
+	keys := make([]string, 0, len(ident))
+	for key, id := range ident {
+		if id != strings.ToUpper(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		l.Infof("envi", "%s -> %s", key, ident[key])
+		l.Break()
+	}
+	for _, c := range sh.Collisions() {
+		l.Warnf("envi", "identifier collision: %s", c)
+		l.Break()
+	}
+	l.Infof("envi", "generating shell environment: %s ...", sh.Name)
+	_, err := sh.Commit()
+	l.Eolf("envi", err, " (ok)")
+	return err
+}
+
+// parseConfig parses and validates the configuration file at the given path
+// (or config.Stdin), logging its progress.
+func parseConfig(l *log.Log, path string) (*config.Config, error) {
+	name := path
+	if path == config.Stdin {
+		name = "<stdin>"
+	}
+	l.Infof("conf", "parsing configuration file: %s ...", name)
+	cfg, err := config.Parse(path)
+	if nil == err {
+		err = cfg.Validate()
+	}
+	l.Eolf("conf", err, " (ok)")
+	if nil != err {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// expandExport returns the given export name and configuration with variable
+// substitution performed on each of its fields. If the given cache directory
+// is non-empty, it contains the working copy of an export without a local
+// path.
+func expandExport(ex *expander, cacheDir, name string, expo config.ExportConfig) (string, config.ExportConfig) {
+	name, expo = ex.expand(name), expo.Expand(ex.expand)
+	if expo.Local == "" && cacheDir != "" {
+		expo.Local = filepath.Join(cacheDir, name)
+	}
+	return name, expo
+}
+
+// svnCommand returns the svn command configured by the given configuration,
+// with variable substitution performed on each of its fields, overridden by the
+// given options (see Options.Svn).
+func svnCommand(ex *expander, cfg *config.Config, o Options) config.SvnConfig {
+	svn := config.SvnConfig{Path: ex.expand(cfg.Svn.Path)}
+	for _, arg := range cfg.Svn.Args {
+		svn.Args = append(svn.Args, ex.expand(arg))
+	}
+	if o.Svn.Path != "" {
+		svn.Path = o.Svn.Path
+	}
+	svn.Args = append(svn.Args, o.Svn.Args...)
+	return svn
+}
+
+// requireUpdate returns RepositoryUnchanged listing each of the named
+// repositories whose exported revision is the same as its previous revision,
+// or that is not an exported repository at all.
+func requireUpdate(names []string, reps map[string]*repo.Repo, revs map[string]revRange) error {
+	var unchanged []string
+	for _, name := range names {
+		if _, ok := reps[name]; !ok {
+			unchanged = append(unchanged, name+" (unknown repository)")
+		} else if rev := revs[name]; rev.from == rev.to {
+			unchanged = append(unchanged, name)
 		}
-		l.Eolf(mode.String(), err, " (%s)", vers)
+	}
+	if len(unchanged) > 0 {
+		return RepositoryUnchanged(strings.Join(unchanged, ", "))
+	}
+	return nil
+}
+
+// flushEnv writes the named section of the given shell environment, if it is
+// incremental (see ShellEnv.Flush), logging only failure.
+func flushEnv(l *log.Log, sh *ShellEnv, section string) {
+	if _, err := sh.Flush(section); nil != err {
+		l.Warnf("envi", "%s: %s", sh.Name, err)
+		l.Break()
+	}
+}
+
+// appendRevs records the previous and current revisions of the named
+// repository in its section of the given shell environment. The placeholder
+// for the previous revision is removed if there is none (i.e., first run), so
+// that the script does not define a misleading empty variable.
+func appendRevs(sh *ShellEnv, name, prev, curr string) {
+	if prev == "" {
+		sh.Remove(name, "REPO_"+name+"_PREVREV")
+	} else {
+		sh.Append(name, "REPO_"+name+"_PREVREV", prev)
+	}
+	sh.Append(name, "REPO_"+name+"_CURRREV", curr)
+}
+
+// openRepo initializes the repository of the given export, with the given svn
+// command and network operation timeout, and verifies we can connect to it,
+// logging its progress.
+func openRepo(l *log.Log, name string, expo config.ExportConfig, svn config.SvnConfig, retries int, timeout time.Duration) (*repo.Repo, error) {
+	l.Infof("repo", "initializing repostiory: %s ...", name)
+	rep, err := repo.New(expo, svn)
+	l.Eolf("repo", err, " (ok)")
+	if nil != err {
+		return nil, err
+	}
+	rep.SetTimeout(timeout)
+
+	l.Infof("ping", "checking repository status: %s ...", name)
+	_, err = rep.IsConnected(retries, retryLogger(l))
+	l.Eolf("ping", err, " (online)")
+	logOutput(l, "ping", err)
+	if nil != err {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// remoteUpToDate returns true if and only if the revision of every remote
+// repository is equal to its given last exported revision, logging the
+// revision of each. It returns false as soon as any revision differs or cannot
+// be determined.
+func remoteUpToDate(l *log.Log, reps map[string]*repo.Repo, last map[string]string) bool {
+	for name, rep := range reps {
+		l.Infof("stat", "querying remote revision: %s ...", name)
+		remote, err := rep.RemoteRevision()
 		if nil != err {
+			l.Putf(" (unknown)")
+			l.Break()
+			return false
+		}
+		l.Putf(" (%s)", remote)
+		l.Break()
+		if remote != last[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// packager contains the state shared by all package operations of a run.
+type packager struct {
+	l         *log.Log
+	sh        *ShellEnv
+	sum       *summary
+	ex        *expander
+	reps      map[string]*repo.Repo
+	revs      map[string]revRange // revisions exported from each repository
+	keep      bool                // continue with remaining operations after copy/archive errors
+	allowAbs  bool                // allow absolute copy destinations outside of the package
+	deref     bool                // archive the files referred to by symbolic links
+	cache     string              // directory of working copies hard linked into packages
+	interrupt <-chan struct{}     // closed to stop starting new packages
+}
+
+// revRange represents the previous and current revisions of an exported
+// repository.
+type revRange struct {
+	from, to string
+}
+
+// revisionVars returns the variables defining the previous and current
+// revisions of each exported repository: $REPO_<name>_PREVREV and
+// $REPO_<name>_CURRREV.
+func (p *packager) revisionVars() map[string]string {
+	vars := map[string]string{}
+	for name, rev := range p.revs {
+		vars["$REPO_"+name+"_PREVREV"] = rev.from
+		vars["$REPO_"+name+"_CURRREV"] = rev.to
+	}
+	return vars
+}
+
+// fail returns the given error if the receiver does not keep going after
+// errors; otherwise, it appends the error to errs and returns nil.
+func (p *packager) fail(errs *MultiError, err error) error {
+	if !p.keep {
+		return err
+	}
+	*errs = append(*errs, err)
+	return nil
+}
+
+// makePackages builds each of the given packages with makePackage, using a
+// pool of up to jobs concurrent workers. Each worker buffers its log output and
+// flushes it all at once, so that lines from concurrent packages do not
+// interleave. The errors of all packages that keep going are appended to errs.
+// Once any package fails otherwise, no further packages are started, and its
+// error is returned. Once the receiver is interrupted, no further packages are
+// started, and InterruptedError is returned.
+func (p *packager) makePackages(pkgs config.PackageMap, jobs int, errs *MultiError) error {
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex // guards p.l, errs, and err
+		err error
+	)
+	paths := make(chan string)
+	abort := make(chan struct{})
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pkgPath := range paths {
+				select {
+				case <-abort:
+					continue
+				case <-p.interrupt:
+					continue
+				default:
+				}
+				// each worker has its own log and expander, since neither is safe
+				// for concurrent use.
+				var buf bytes.Buffer
+				w := *p
+				w.l, w.ex = p.l.Redirect(&buf), p.ex.within(p.ex.scope)
+				if jobs == 1 {
+					// nothing can interleave with a single worker, so log directly.
+					w.l = p.l
+				}
+				perr := w.makePackage(pkgPath, pkgs[pkgPath])
+				mu.Lock()
+				p.l.Putf("%s", buf.String())
+				// if keep-going, makePackage only returns fatal errors that are not
+				// related to copy or archive operations.
+				if me, ok := perr.(MultiError); ok {
+					*errs = append(*errs, me...)
+				} else if nil != perr && nil == err {
+					err = perr
+					close(abort)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for pkgPath := range pkgs {
+		if interrupted(p.interrupt) {
+			break
+		}
+		select {
+		case paths <- pkgPath:
+		case <-abort:
+			break dispatch
+		case <-p.interrupt:
+			break dispatch
+		}
+	}
+	close(paths)
+	wg.Wait()
+	if interrupted(p.interrupt) {
+		err = InterruptedError("remaining packages not built")
+		p.l.Errorf("intr", "%s", err)
+		p.l.Break()
+	}
+	return err
+}
+
+// makePackage copies all included content into the given package path and
+// creates its compressed archive (if configured), logging its progress.
+// If the receiver keeps going after errors, all copy and archive errors are
+// returned together as a MultiError.
+func (p *packager) makePackage(pkgPath string, pkg config.PackageConfig) error {
+
+	l, ex := p.l, p.ex
+
+	var errs MultiError
+
+	// perform string replacement with variables on the package path.
+	pkgPath = ex.expand(pkgPath)
+	if err := ex.check(l); nil != err {
+		return err
+	}
+
+	// archive the included content directly from its sources, without copying
+	// it into the package directory, if configured and possible.
+	var direct *directTree
+	if pkg.Compress.Direct {
+		if reason := directUnsupported(pkg); reason != "" {
+			l.Warnf("pack", "%s: compress direct unsupported with %s, copying into package", pkgPath, reason)
+			l.Break()
+		} else {
+			direct = newDirectTree(pkgPath)
+		}
+	}
+
+	if err := p.runHooks(ex, pkgPath, "", pkg.PreHook); nil != err {
+		return err
+	}
+
+	// names of the repositories included in the package, for its changelog, and
+	// the source of each copy, for its roster.
+	var (
+		incRepos []string
+		sources  []rosterSource
+	)
+
+	// paths copied into the package, and the destinations containing them, to
+	// be pruned of all other content.
+	var (
+		produced   = map[string]bool{}
+		pruneRoots []string
+	)
+
+	// walk over each repository we are copying content from for the current
+	// output package.
+	for _, inc := range pkg.Include {
+
+		var srcPath, srcRepo string
+		var incList config.IncludePathList
+
+		for path, list := range inc { // only 1 key-value pair
+			// perform string replacement with variables on the include path.
+			path = ex.expand(path)
+			if err := ex.check(l); nil != err {
+				return err
+			}
+			srcPath = path
+			incList = list
+			if rep, isRepo := p.reps[path]; isRepo {
+				srcPath, srcRepo = rep.LocalPath(), path
+				incRepos = append(incRepos, path)
+			}
+		}
+
+		// walk over each include operation for the current repository.
+		for _, op := range incList {
+			// check if there is a copy operation
+			if cp := op.Copy; cp.Repo != "" && len(cp.Package) > 0 {
+				// perform string replacement with variables on the copy fields.
+				cp = cp.Expand(ex.expand)
+				if err := ex.check(l); nil != err {
+					return err
+				}
+				// the default actions are used for any that were not recognized.
+				if _, ok := symlinkAction(cp.Symlinks); !ok {
+					l.Warnf("copy", "unrecognized symlinks action %q (expected deep, shallow, or skip), using default: skip", cp.Symlinks)
+					l.Break()
+				}
+				if _, ok := dirExistsAction(cp.Conflict); !ok {
+					l.Warnf("copy", "unrecognized conflict action %q (expected merge, replace, or skip), using default: merge", cp.Conflict)
+					l.Break()
+				}
+				// skip the copy operation if its condition evaluates false, before
+				// resolving (and validating) any of its paths.
+				ok, err := evalCondition(ex, cp.When)
+				if verr := ex.check(l); nil != verr {
+					return verr
+				}
+				if nil == err && !ok {
+					l.Infof("copy", "%s -> %s (skipped, when: %s)", cp.Repo, strings.Join(cp.Package, ", "), cp.When)
+					l.Break()
+					continue
+				}
+				if nil != err {
+					l.Infof("copy", "%s -> %s", cp.Repo, strings.Join(cp.Package, ", "))
+					l.Eolf("copy", err, "")
+					if err = p.fail(&errs, err); nil != err {
+						return err
+					}
+					continue
+				}
+				// copy the source path to each of its package destinations.
+				for _, pkgDst := range cp.Package {
+					var oversized []skippedFile
+					var copied func(path string)
+					if pkg.Prune {
+						copied = func(path string) { produced[filepath.Clean(path)] = true }
+					}
+					src, dst, opt, err := copyOptions(srcPath, pkgPath, pkgDst, cp, pkg.ExcludesVcsMeta(), p.allowAbs,
+						func(path string, size int64) {
+							oversized = append(oversized, skippedFile{path, size})
+						}, copied)
+					l.Infof("copy", "%s -> %s", src, dst)
+					// only destinations actually written by this run are pruned, and
+					// never those left untouched by the skip conflict action.
+					prunable := pkg.Prune && (nil == direct || !within(dst, filepath.Clean(pkgPath)))
+					if conflict, _ := dirExistsAction(cp.Conflict); prunable && conflict == copy.Untouchable {
+						if info, err := os.Stat(dst); nil == err && info.IsDir() {
+							prunable = false
+						}
+					}
+					if nil == err {
+						switch {
+						case nil != direct && within(dst, filepath.Clean(pkgPath)):
+							err = direct.add(src, dst, opt)
+						case cp.Symlink:
+							err = linkTree(src, dst, opt, symlinkFile)
+						case cp.Flatten:
+							err = flattenCopy(src, dst, opt, cp.Collision, cp.Verify, copied)
+						case p.hardlinks(src, cp):
+							err = linkTree(src, dst, opt, hardlinkFunc(opt))
+						case p.cache != "":
+							// never overwrite files in place, which may be hard links
+							// into the cache from another copy operation.
+							opt.Skip = unlinkCopied(src, dst, opt.Skip)
+							if err = unlinkFile(dst); nil == err {
+								err = copy.Copy(src, dst, opt)
+							}
+						default:
+							err = copy.Copy(src, dst, opt)
+						}
+					}
+					if nil == err {
+						err = copyModes(dst, cp)
+					}
+					// failing to change ownership (e.g., without privilege) does
+					// not fail the copy.
+					var ownerErr error
+					if nil == err && cp.PreserveOwner {
+						ownerErr = chownTree(src, dst)
+					}
+					l.Eolf("copy", err, " (ok)")
+					if nil != ownerErr {
+						l.Warnf("copy", "%s (ownership not preserved: %s)", dst, ownerErr)
+						l.Break()
+					}
+					for _, f := range oversized {
+						l.Warnf("copy", "%s (skipped, size %d exceeds maxFileSize: %s)", f.path, f.size, cp.MaxFileSize)
+						l.Break()
+						p.sum.addSkipped(pkgPath, f.path, f.size)
+					}
+					if nil != err {
+						if err = p.fail(&errs, err); nil != err {
+							return err
+						}
+						continue
+					}
+					p.sum.addCopy(pkgPath, dst)
+					sources = append(sources, rosterSource{dst: dst, repo: srcRepo})
+					if prunable {
+						produced[filepath.Clean(dst)] = true
+						pruneRoots = append(pruneRoots, dst)
+					}
+				}
+			}
+		}
+	}
+
+	// remove the content of each destination that was not copied by this run
+	// (e.g., files deleted from the repository), if enabled.
+	if pkg.Prune {
+		if err := p.prune(pruneRoots, produced); nil != err {
+			if err = p.fail(&errs, err); nil != err {
+				return err
+			}
+		}
+	}
+
+	// write the log of changes made to each included repository, if enabled.
+	if pkg.Changelog {
+		if err := p.writeChangelog(pkgPath, incRepos); nil != err {
+			if err = p.fail(&errs, err); nil != err {
+				return err
+			}
+		}
+	}
+
+	// write the list of files in the package, if enabled, so that it is
+	// included in the archive.
+	if pkg.Roster {
+		if err := p.writeRoster(pkgPath, sources); nil != err {
+			if err = p.fail(&errs, err); nil != err {
+				return err
+			}
+		}
+	}
+
+	// create a compressed archive of the package if the output path is defined.
+	var archive string
+	if pkg.Compress.Output != "" && hasLinks(pkg) && !p.deref {
+		// an archive of links to files on this system would be useless anywhere
+		// else.
+		l.Warnf("pack", "%s: archive skipped, package contains symbolic links to its sources (see -deref)", pkgPath)
+		l.Break()
+	} else if pkg.Compress.Output != "" {
+		// perform string replacement with variables on the output path.
+		pkg.Compress.Output = ex.expand(pkg.Compress.Output)
+		if err := ex.check(l); nil != err {
 			return err
 		}
-		// update the last revision in the Config struct
-		if expo, ok := cfg.Export[name]; ok {
-			if expo.Last != vers {
-				didUpdate = true
+		arcPath, err := p.makeArchive(pkgPath, pkg.Compress, direct)
+		if nil != err {
+			if err = p.fail(&errs, err); nil != err {
+				return err
+			}
+		}
+		archive = arcPath
+	}
+
+	if len(errs) > 0 {
+		// do not run the post-hooks (e.g., signing) on an incomplete package.
+		return errs
+	}
+	return p.runHooks(ex, pkgPath, archive, pkg.PostHook)
+}
+
+// makeArchive creates the compressed archive of the given package path and
+// its checksum files, logging its progress, and returns the path of the
+// archive. If direct is non-nil, the archive contains its content instead of
+// the content of the package path.
+// If the compress configuration continues on error, entries that cannot be
+// written are skipped, and IncompleteArchiveError is returned after the
+// archive is complete unless the receiver keeps going after errors.
+func (p *packager) makeArchive(pkgPath string, cfg config.CompressConfig, direct *directTree) (string, error) {
+
+	l := p.l
+
+	var skipped []error
+	arcPath, arc, err := makeArchiver(pkgPath, cfg)
+
+	// entries are added to an existing archive only if its format permits,
+	// otherwise it is rebuilt.
+	var base string
+	if nil == err && cfg.Append {
+		if _, serr := os.Stat(arcPath); nil == serr {
+			if appendable(arc) {
+				base = arcPath
+			} else {
+				l.Warnf("pack", "%s: cannot append to %s archive, rebuilding", arcPath, cfg.Method)
+				l.Break()
+			}
+		}
+	}
+
+	// fail rather than create the output directory, if so configured.
+	if nil == err && !cfg.CreatesDirs() {
+		if info, serr := os.Stat(filepath.Dir(arcPath)); nil != serr || !info.IsDir() {
+			err = fmt.Errorf("output directory not found (createDirs is false): %s", filepath.Dir(arcPath))
+		}
+	}
+
+	l.Infof("pack", "%s -> %s", pkgPath, arcPath)
+	if nil == err {
+		// write the archive to a temporary file that is renamed only once it is
+		// complete, so that an interrupted run never leaves a truncated archive.
+		// entries that cannot be written are skipped if configured to continue,
+		// which requires writing each entry individually.
+		top := topLevelFolder(pkgPath, arcPath, cfg.TopLevelFolder)
+		var skip func(name string, err error)
+		if cfg.ContinueOnError {
+			skip = func(name string, err error) { skipped = append(skipped, err) }
+		}
+		write := func(tmp string) error {
+			if nil != direct {
+				w, ok := arc.(archiver.Writer)
+				if !ok {
+					return InvalidCompressMethod(cfg.Method + " (cannot archive directly)")
+				}
+				return writeArchive(w, tmp, direct.entries(pkgPath, top), cfg.Order, cfg.Reproducible, p.deref, skip)
+			}
+			if w, ok := arc.(archiver.Writer); ok && (cfg.Reproducible || cfg.ContinueOnError || p.deref ||
+				cfg.Order != "" || top != filepath.Base(filepath.Clean(pkgPath))) {
+				return archiveTree(w, pkgPath, tmp, top, cfg.Order, cfg.Reproducible, p.deref, skip)
+			}
+			return arc.Archive([]string{pkgPath}, tmp)
+		}
+		err = writeAtomic(arcPath, cfg.Overwrite || cfg.Append, func(tmp string) error {
+			if base != "" {
+				return appendArchive(arc, base, tmp, write)
 			}
-			sh.Append(name, "REPO_"+name+"_PREVREV", expo.Last)
-			sh.Append(name, "REPO_"+name+"_CURRREV", vers)
-			expo.Last = vers
-			cfg.Export[name] = expo
+			return write(tmp)
+		})
+	}
+	if nil == err {
+		var info os.FileInfo
+		if info, err = os.Stat(arcPath); nil == err {
+			p.sum.setArchive(pkgPath, arcPath, info.Size())
 		}
 	}
+	if len(skipped) > 0 {
+		l.Eolf("pack", err, " (%d entries skipped)", len(skipped))
+	} else if base != "" {
+		l.Eolf("pack", err, " (appended)")
+	} else {
+		l.Eolf("pack", err, " (ok)")
+	}
+	if nil != err {
+		return "", err
+	}
+	for _, e := range skipped {
+		l.Warnf("pack", "%s (skipped)", e)
+		l.Break()
+	}
 
-	l.Infof("envi", "generating shell environment: %s ...", sh.Name)
-	_, err = sh.Commit()
-	l.Eolf("envi", err, " (ok)")
-	if err != nil {
-		return err
+	// write a checksum sidecar file for each configured algorithm.
+	for _, algo := range cfg.Checksum {
+		l.Infof("hash", "%s -> %s", arcPath, checksumPath(arcPath, algo))
+		hash, err := writeChecksum(arcPath, algo)
+		l.Eolf("hash", err, " (%s)", hash)
+		if nil != err {
+			return "", err
+		}
+		p.sh.Append(pkgPath, checksumKey(pkgPath, algo), hash)
 	}
+	flushEnv(l, p.sh, pkgPath)
 
-	// return early if user provided update flag -u and we did not update
-	// any working copy.
-	if upToDate := WorkingCopiesUpToDate(update && !didUpdate); upToDate {
-		l.Errorf("conf", "%s", upToDate)
+	// the archive path is the only output in quiet mode.
+	if l.Quiet() {
+		l.Putf("%s", arcPath)
 		l.Break()
-		return upToDate
 	}
 
-	// parse the configuration file if it is valid YAML format.
-	l.Infof("conf", "writing repository revisions: %s ...", path)
-	err = cfg.Write()
-	l.Eolf("conf", err, " (ok)")
-	if nil != err {
-		return err
+	// the archive is complete, but it is still a failure to skip any entries
+	// unless keeping going.
+	if len(skipped) > 0 && !p.keep {
+		return arcPath, IncompleteArchiveError(fmt.Sprintf("%s: %d entries skipped", arcPath, len(skipped)))
 	}
+	return arcPath, nil
+}
 
-	// walk over each declared output package
-	for pkgPath, pkg := range cfg.Package {
-
-		// perform string replacement with variables on the package path.
-		for ident, value := range Variable {
-			pkgPath = strings.ReplaceAll(pkgPath, ident, value)
+// exportRepo retrieves the given repository by either update or checkout,
+// logging its progress to l, and returns the revision of the working copy.
+// If verbose is true, each line of output from the export command is logged.
+// The existing working copy is discarded and checked out anew, instead of
+// updated, according to force.
+func exportRepo(l *log.Log, rep *repo.Repo, retries int, verbose bool, force ForceCheckout) (string, error) {
+	var vers string
+	mode, _ := rep.Exporter()
+	if mode == repo.UpdateMode && force == AlwaysForceCheckout {
+		if err := discardRepo(l, rep); nil != err {
+			return "", err
 		}
+		mode, _ = rep.Exporter()
+	}
+	class := mode.String()
+	l.Infof(class, "%s -> %s", rep.Remote(), rep.LocalPath())
+	wait := retryLogger(l)
+	if verbose {
+		l.Putf(" ...")
+		l.Break()
+		rep.SetProgress(l.Writer(class))
+		defer rep.SetProgress(nil)
+		wait = func(attempt int, delay time.Duration) {
+			l.Infof(class, "attempt %d failed, retry in %s", attempt, delay)
+			l.Break()
+		}
+	}
+	err := rep.Export(retries, wait)
+	if nil == err {
+		vers, err = rep.Revision()
+	}
+	if verbose {
+		l.Infof(class, "%s", rep.LocalPath())
+	}
+	l.Eolf(class, err, " (%s)", vers)
+	logOutput(l, class, err)
+	if nil != err && mode == repo.UpdateMode && force == ForceCheckoutOnFailure {
+		if err := discardRepo(l, rep); nil != err {
+			return "", err
+		}
+		return exportRepo(l, rep, retries, verbose, NeverForceCheckout)
+	}
+	return vers, err
+}
 
-		// walk over each repository we are copying content from for the current
-		// output package.
-		for _, inc := range pkg.Include {
-
-			var srcPath string
-			var incList config.IncludePathList
+// logOutput logs each line of the output of the failed VCS command described by
+// the given error (see repo.ExportFailedError), if any.
+func logOutput(l *log.Log, class string, err error) {
+	var out string
+	switch e := err.(type) {
+	case repo.ConnectionFailedError:
+		out = e.Output
+	case repo.ExportFailedError:
+		out = e.Output
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			l.Errorf(class, "  %s", line)
+			l.Break()
+		}
+	}
+}
 
-			for path, list := range inc { // only 1 key-value pair
-				// perform string replacement with variables on the include path.
-				for ident, value := range Variable {
-					path = strings.ReplaceAll(path, ident, value)
-				}
-				srcPath = path
-				incList = list
-				if rep, isRepo := reps[path]; isRepo {
-					srcPath = rep.LocalPath()
-				}
-			}
+// discardRepo removes the working copy of the given repository, logging its
+// progress to l.
+func discardRepo(l *log.Log, rep *repo.Repo) error {
+	l.Warnf("wipe", "discarding working copy: %s ...", rep.LocalPath())
+	err := rep.Discard()
+	l.Eolf("wipe", err, " (ok)")
+	return err
+}
 
-			// walk over each include operation for the current repository.
-			for _, op := range incList {
-				// check if there is a copy operation
-				if cp := op.Copy; cp.Repo != "" && cp.Package != "" {
-					// perform string replacement with variables on the copy fields.
-					for ident, value := range Variable {
-						cp.Repo = strings.ReplaceAll(cp.Repo, ident, value)
-						cp.Package = strings.ReplaceAll(cp.Package, ident, value)
-						for i := range cp.Ignore {
-							cp.Ignore[i] = strings.ReplaceAll(cp.Ignore[i], ident, value)
-						}
-					}
-					src, dst, opt, err := copyOptions(srcPath, pkgPath, cp)
-					l.Infof("copy", "%s -> %s", src, dst)
-					if nil == err {
-						err = copy.Copy(src, dst, opt)
-					}
-					l.Eolf("copy", err, " (ok)")
-					if nil != err {
-						return err
-					}
-				}
-			}
-		}
+// retryLogger returns a repo.RetryFunc that appends each retry attempt and its
+// delay to the current line of the given log.
+func retryLogger(l *log.Log) repo.RetryFunc {
+	return func(attempt int, delay time.Duration) {
+		l.Putf(" (attempt %d failed, retry in %s)", attempt, delay)
+	}
+}
 
-		// create a compressed archive of the package if the output path is defined.
-		if pkg.Compress.Output != "" {
-			// perform string replacement with variables on the output path.
-			for ident, value := range Variable {
-				pkg.Compress.Output =
-					strings.ReplaceAll(pkg.Compress.Output, ident, value)
-			}
-			arcPath, arc, err := makeArchiver(pkgPath, pkg.Compress)
-			l.Infof("pack", "%s -> %s", pkgPath, arcPath)
-			if nil == err {
-				err = arc.Archive([]string{pkgPath}, arcPath)
-			}
-			l.Eolf("pack", err, " (ok)")
-			if nil != err {
-				return err
-			}
+// isSecret returns true if and only if the given variable identifier is
+// referenced by the password field of any export in the given configuration.
+func isSecret(cfg *config.Config, ident string) bool {
+	for _, expo := range cfg.Export {
+		if strings.Contains(expo.Password, ident) {
+			return true
 		}
 	}
+	return false
+}
 
-	return nil
+// copyModes sets the permissions of the copied tree at dst to the directory
+// and file modes of the given copy configuration, if defined.
+func copyModes(dst string, cfg config.IncludeCopyConfig) error {
+	dirMode, err := config.ParseMode(cfg.DirMode)
+	if nil != err {
+		return err
+	}
+	fileMode, err := config.ParseMode(cfg.FileMode)
+	if nil != err {
+		return err
+	}
+	return chmodTree(dst, dirMode, fileMode)
 }
 
-func copyOptions(srcPath, pkgPath string, cfg config.IncludeCopyConfig) (string, string, copy.Options, error) {
+// copyOptions returns the source and destination paths of the given copy
+// configuration, copying to package path dst, along with its copy options.
+// If excludeMeta is true, VCS metadata files are skipped in addition to the
+// configured ignore patterns.
+// UnsafeDestinationError is returned if dst is a relative path outside of the
+// package path (e.g., "../x"), or an absolute path and allowAbs is false.
+// If non-nil, oversize is called with each file skipped for its size, and
+// copied is called with the destination path of each file and directory that
+// is copied, or that is already identical to its source (see Verify), except
+// for flattened copies (see flattenCopy).
+func copyOptions(srcPath, pkgPath, dst string, cfg config.IncludeCopyConfig, excludeMeta, allowAbs bool, oversize func(path string, size int64), copied func(path string)) (string, string, copy.Options, error) {
 	// if repo path is not an asbolute path, append it to the repository local
 	// working copy path.
 	src := cfg.Repo
 	if !filepath.IsAbs(src) {
 		src = filepath.Join(srcPath, src)
 	}
+	// a destination path with a trailing separator always refers to a directory.
+	dstDir := strings.HasSuffix(dst, "/") || strings.HasSuffix(dst, string(filepath.Separator))
 	// if destination path is not an absolute path, append it to the package root
-	// path.
-	dst := cfg.Package
+	// path, which it must not escape.
 	if !filepath.IsAbs(dst) {
-		dst = filepath.Join(pkgPath, dst)
+		rel := dst
+		if dst = filepath.Join(pkgPath, dst); !within(dst, filepath.Clean(pkgPath)) {
+			return src, dst, copy.Options{}, UnsafeDestinationError(rel + ": outside of package " + pkgPath)
+		}
+	} else if !allowAbs {
+		return src, dst, copy.Options{}, UnsafeDestinationError(dst + ": absolute path")
+	}
+	// a single file is copied to exactly the destination path (i.e., renamed if
+	// the base names differ), unless the destination refers to a directory, in
+	// which case the file is copied into it.
+	if info, err := os.Stat(src); nil == err && info.Mode().IsRegular() {
+		if dinfo, err := os.Stat(dst); dstDir || (nil == err && dinfo.IsDir()) {
+			dst = filepath.Join(dst, filepath.Base(src))
+		}
 	}
 	// convert the given copy option strings to their enumerated values.
-	symlinks := symlinkAction(cfg.Symlinks)
-	conflict := dirExistsAction(cfg.Conflict)
-	skip, err := skipFunc(cfg.Ignore...)
+	symlinks, _ := symlinkAction(cfg.Symlinks)
+	conflict, _ := dirExistsAction(cfg.Conflict)
+	// patterns are matched against paths relative to the copy source, so that
+	// they never match any of the directories containing it.
+	ignore, err := skipFunc(cfg.IgnoreStyle, cfg.IgnoreCaseInsensitive, cfg.Ignore...)
+	if nil != err {
+		return src, dst, copy.Options{}, err
+	}
+	ignore = relativeMatch(src, ignore)
+	if excludeMeta {
+		ignorePattern := ignore
+		ignore = func(s string) bool { return isVcsMeta(s) || ignorePattern(s) }
+	}
+	var only func(string) bool
+	if len(cfg.Only) > 0 {
+		if only, err = skipFunc(cfg.IgnoreStyle, cfg.IgnoreCaseInsensitive, cfg.Only...); nil != err {
+			return src, dst, copy.Options{}, err
+		}
+		only = relativeMatch(src, only)
+	}
+	maxSize, err := config.ParseSize(cfg.MaxFileSize)
+	if nil != err {
+		return src, dst, copy.Options{}, err
+	}
+	since, err := config.ParseSince(cfg.ModifiedSince, time.Now())
+	if nil != err {
+		return src, dst, copy.Options{}, err
+	}
+	// only files accepted by want are copied, if non-nil.
+	var want *wantedTree
+	if nil != only || !since.IsZero() {
+		want = newWantedTree(ignore, func(s string, info os.FileInfo) bool {
+			return (nil == only || only(s)) &&
+				(since.IsZero() || !info.ModTime().Before(since))
+		})
+	}
 	// construct a copy.Options struct with given configuration.
 	return src, dst, copy.Options{
 		OnSymlink:     func(s string) copy.SymlinkAction { return symlinks },
 		OnDirExists:   func(s, d string) copy.DirExistsAction { return conflict },
-		Skip:          func(s string) (bool, error) { return skip(s), nil },
+		Skip: func(s string) (bool, error) {
+			skip, err := skipPath(s, ignore, want)
+			if !skip && nil == err && maxSize > 0 {
+				// skip files larger than the maximum size, reporting each one.
+				var info os.FileInfo
+				if info, err = os.Lstat(s); nil == err &&
+					info.Mode().IsRegular() && info.Size() > maxSize {
+					skip = true
+					if nil != oversize {
+						oversize(s, info.Size())
+					}
+				}
+			}
+			// flattened copies are recorded and verified by flattenCopy, which
+			// alone knows the destination of each file.
+			if !skip && nil == err && !cfg.Flatten && (cfg.Verify || nil != copied) {
+				var rel string
+				if rel, err = filepath.Rel(src, s); nil == err {
+					if nil != copied {
+						copied(filepath.Join(dst, rel))
+					}
+					// skip files whose content is identical to their destination.
+					if cfg.Verify {
+						skip, err = sameContent(s, filepath.Join(dst, rel))
+					}
+				}
+			}
+			return skip, err
+		},
 		Sync:          true,
 		PreserveTimes: true,
 	}, err
 }
 
-func symlinkAction(action string) copy.SymlinkAction {
+// relativeMatch returns a function that reports whether match accepts the
+// slash-separated path of a file relative to directory root. Directories are
+// also tested with a trailing slash, so that a pattern such as "^build/"
+// matches the directory itself, and not only its contents.
+func relativeMatch(root string, match func(string) bool) func(string) bool {
+	return func(s string) bool {
+		rel, err := filepath.Rel(root, s)
+		if nil != err {
+			return match(filepath.ToSlash(s))
+		}
+		if rel = filepath.ToSlash(rel); match(rel) {
+			return true
+		}
+		info, err := os.Lstat(s)
+		return nil == err && info.IsDir() && match(rel+"/")
+	}
+}
+
+// skippedFile identifies a file that was not copied, and its size.
+type skippedFile struct {
+	path string
+	size int64
+}
+
+// skipPath returns true if the given path should not be copied, because it
+// matches ignore, or because want is non-nil and does not accept the file.
+// Directories are never skipped due to want unless none of their descendants
+// would be copied.
+func skipPath(s string, ignore func(string) bool, want *wantedTree) (bool, error) {
+	if ignore(s) {
+		return true, nil
+	}
+	if nil == want {
+		return false, nil
+	}
+	info, err := os.Lstat(s)
+	if nil != err {
+		return false, err
+	}
+	if !info.IsDir() {
+		return !want.accept(s, info), nil
+	}
+	found, err := want.contains(s)
+	return !found, err
+}
+
+// wantedTree records which directories contain a file that would be copied,
+// so that each directory tree is walked only once, rather than once for each
+// of its subdirectories.
+type wantedTree struct {
+	ignore func(string) bool
+	accept func(string, os.FileInfo) bool
+	walked []string        // roots of each walked tree
+	found  map[string]bool // directories with a descendant that would be copied
+}
+
+// newWantedTree returns a new wantedTree for the files accepted by the given
+// function and not matched by ignore.
+func newWantedTree(ignore func(string) bool, accept func(string, os.FileInfo) bool) *wantedTree {
+	return &wantedTree{ignore: ignore, accept: accept, found: map[string]bool{}}
+}
+
+// contains returns true if the given directory has a descendant that would be
+// copied, walking the directory if it is not in any tree already walked (e.g.,
+// the copy source, or the target of a symbolic link followed by a deep copy).
+func (w *wantedTree) contains(dir string) (bool, error) {
+	dir = filepath.Clean(dir)
+	for _, root := range w.walked {
+		if within(dir, root) {
+			return w.found[dir], nil
+		}
+	}
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		if w.ignore(p) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.IsDir() && w.accept(p, fi) {
+			// mark each ancestor, stopping at the first already marked.
+			for d := filepath.Dir(p); !w.found[d]; d = filepath.Dir(d) {
+				w.found[d] = true
+				if d == dir {
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if nil != err {
+		return false, err
+	}
+	w.walked = append(w.walked, dir)
+	return w.found[dir], nil
+}
+
+// symlinkAction returns the copy.SymlinkAction named by the given string, and
+// true if the name was recognized. An empty name is recognized as the default
+// action. Otherwise, DefaultSymlinkAction and false are returned.
+func symlinkAction(action string) (copy.SymlinkAction, bool) {
 	switch strings.ToLower(action) {
+	case "":
+		return DefaultSymlinkAction, true
 	case "deep":
-		return copy.Deep
+		return copy.Deep, true
 	case "shallow":
-		return copy.Shallow
+		return copy.Shallow, true
 	case "skip":
-		return copy.Skip
+		return copy.Skip, true
 	}
-	return DefaultSymlinkAction
+	return DefaultSymlinkAction, false
 }
 
-func dirExistsAction(action string) copy.DirExistsAction {
+// dirExistsAction returns the copy.DirExistsAction named by the given string,
+// and true if the name was recognized. An empty name is recognized as the
+// default action. Otherwise, DefaultDirExistsAction and false are returned.
+func dirExistsAction(action string) (copy.DirExistsAction, bool) {
 	switch strings.ToLower(action) {
+	case "":
+		return DefaultDirExistsAction, true
 	case "merge":
-		return copy.Merge
+		return copy.Merge, true
 	case "replace":
-		return copy.Replace
+		return copy.Replace, true
 	case "skip", "ignore", "untouchable":
-		return copy.Untouchable
+		return copy.Untouchable, true
+	}
+	return DefaultDirExistsAction, false
+}
+
+// vcsMeta contains the base names of files and directories containing VCS
+// metadata.
+var vcsMeta = map[string]bool{
+	".svn": true, ".git": true, ".gitignore": true, ".gitattributes": true,
+	".gitmodules": true, ".hg": true, ".hgignore": true, ".bzr": true,
+}
+
+// isVcsMeta returns true if and only if the given path is a VCS metadata file
+// or directory.
+func isVcsMeta(s string) bool {
+	return vcsMeta[filepath.Base(s)]
+}
+
+func skipFunc(style string, fold bool, ignore ...string) (func(string) bool, error) {
+	switch strings.ToLower(style) {
+	case "", "regex", "regexp":
+		if fold {
+			// prefix the case-insensitive flag without modifying the caller's slice.
+			folded := make([]string, len(ignore))
+			for i, s := range ignore {
+				folded[i] = "(?i)" + s
+			}
+			ignore = folded
+		}
+		return skipRegexp(ignore...)
+	case "glob":
+		match, err := skipGlob(ignore...)
+		if nil != err || !fold {
+			return match, err
+		}
+		lower := make([]string, len(ignore))
+		for i, s := range ignore {
+			lower[i] = strings.ToLower(s)
+		}
+		if match, err = skipGlob(lower...); nil != err {
+			return nil, err
+		}
+		return func(s string) bool { return match(strings.ToLower(s)) }, nil
 	}
-	return DefaultDirExistsAction
+	return nil, InvalidIgnorePattern("unknown ignore style: " + style)
 }
 
-func skipFunc(ignore ...string) (func(string) bool, error) {
+func skipRegexp(ignore ...string) (func(string) bool, error) {
 	// convert the ignore strings to regexp patterns.
 	ign := []*regexp.Regexp{}
 	for _, s := range ignore {
 		re, err := regexp.Compile(s)
 		if nil != err {
-			return nil, InvalidIgnorePattern(s)
+			return nil, InvalidIgnorePattern("regex: " + s)
 		}
 		ign = append(ign, re)
 	}
@@ -307,6 +1598,35 @@ func skipFunc(ignore ...string) (func(string) bool, error) {
 	}, nil
 }
 
+func skipGlob(ignore ...string) (func(string) bool, error) {
+	// verify each of the glob patterns is well-formed.
+	for _, s := range ignore {
+		if _, err := path.Match(s, ""); nil != err {
+			return nil, InvalidIgnorePattern("glob: " + s)
+		}
+	}
+	// return a function that checks if a given path matches any of the ignored
+	// glob patterns. patterns containing a separator are matched against the
+	// entire path; otherwise, they are matched against the base name only.
+	return func(s string) bool {
+		s = filepath.ToSlash(s)
+		for _, g := range ignore {
+			name := s
+			if !strings.Contains(g, "/") {
+				name = path.Base(s)
+			}
+			if ok, _ := path.Match(g, name); ok {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// makeArchiver returns the archive file path and archiver for the given
+// compression configuration. A compression level of 0 selects the default
+// level of the method; any other level must be in the method's valid range
+// (see config.LevelRange), or InvalidCompressLevel is returned.
 func makeArchiver(pkgPath string, cfg config.CompressConfig) (string, archiver.Archiver, error) {
 
 	var (
@@ -315,40 +1635,92 @@ func makeArchiver(pkgPath string, cfg config.CompressConfig) (string, archiver.A
 		err error
 	)
 
+	level := cfg.Level
+	if lo, hi, ok := config.LevelRange(cfg.Method); ok && level != 0 && (level < lo || level > hi) {
+		return cfg.Output, nil, InvalidCompressLevel(fmt.Sprintf(
+			"%d for method %q (expected %d to %d, or 0 for default)", level, cfg.Method, lo, hi))
+	}
+
 	// create an archiver for the declared compression method
 	switch strings.ToLower(cfg.Method) {
 	case "zip", ".zip":
 		ext = ".zip"
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		method := archiver.Deflate
+		if cfg.Store {
+			method = archiver.Store
+		}
 		arc = &archiver.Zip{
-			CompressionLevel:       cfg.Level,
+			CompressionLevel:       level,
+			FileMethod:             method,
 			OverwriteExisting:      cfg.Overwrite,
-			MkdirAll:               true,
+			MkdirAll:               cfg.CreatesDirs(),
 			SelectiveCompression:   true,
 			ImplicitTopLevelFolder: false,
-			ContinueOnError:        false,
+			ContinueOnError:        cfg.ContinueOnError,
+		}
+
+	// plain tar has no compression, so the configured level is ignored.
+	case "tar", ".tar":
+		ext = ".tar"
+		arc = &archiver.Tar{
+			OverwriteExisting:      cfg.Overwrite,
+			MkdirAll:               cfg.CreatesDirs(),
+			ImplicitTopLevelFolder: false,
+			ContinueOnError:        cfg.ContinueOnError,
 		}
 
 	case "gz", ".gz", "tgz", ".tgz", "targz", "tar.gz", ".tar.gz":
 		ext = ".tar.gz"
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
 		arc = &archiver.TarGz{
-			CompressionLevel: cfg.Level,
+			CompressionLevel: level,
 			Tar: &archiver.Tar{
 				OverwriteExisting:      cfg.Overwrite,
-				MkdirAll:               true,
+				MkdirAll:               cfg.CreatesDirs(),
 				ImplicitTopLevelFolder: false,
-				ContinueOnError:        false,
+				ContinueOnError:        cfg.ContinueOnError,
 			},
 		}
 
 	case "bz2", ".bz2", "tbz", ".tbz", "tbz2", ".tbz2", "tarbz2", "tar.bz2", ".tar.bz2":
 		ext = ".tar.bz2"
+		// the bzip2 writer already selects its default level for level 0.
 		arc = &archiver.TarBz2{
-			CompressionLevel: cfg.Level,
+			CompressionLevel: level,
+			Tar: &archiver.Tar{
+				OverwriteExisting:      cfg.Overwrite,
+				MkdirAll:               cfg.CreatesDirs(),
+				ImplicitTopLevelFolder: false,
+				ContinueOnError:        cfg.ContinueOnError,
+			},
+		}
+
+	// the archiver package does not support compression levels for xz or zstd,
+	// so the configured level is ignored and the library defaults are used.
+	case "xz", ".xz", "txz", ".txz", "tarxz", "tar.xz", ".tar.xz":
+		ext = ".tar.xz"
+		arc = &archiver.TarXz{
+			Tar: &archiver.Tar{
+				OverwriteExisting:      cfg.Overwrite,
+				MkdirAll:               cfg.CreatesDirs(),
+				ImplicitTopLevelFolder: false,
+				ContinueOnError:        cfg.ContinueOnError,
+			},
+		}
+
+	case "zst", ".zst", "zstd", "tzst", ".tzst", "tarzst", "tar.zst", ".tar.zst":
+		ext = ".tar.zst"
+		arc = &archiver.TarZstd{
 			Tar: &archiver.Tar{
 				OverwriteExisting:      cfg.Overwrite,
-				MkdirAll:               true,
+				MkdirAll:               cfg.CreatesDirs(),
 				ImplicitTopLevelFolder: false,
-				ContinueOnError:        false,
+				ContinueOnError:        cfg.ContinueOnError,
 			},
 		}
 
@@ -359,35 +1731,70 @@ func makeArchiver(pkgPath string, cfg config.CompressConfig) (string, archiver.A
 	if nil == err {
 		if nil != arc.CheckExt(cfg.Output) {
 			// remove existing extension if it exists, to replace with proper one
-			if e := filepath.Ext(cfg.Output); "" != e {
-				cfg.Output = strings.TrimSuffix(cfg.Output, e)
-			}
-			cfg.Output += ext
+			cfg.Output = trimArchiveExt(cfg.Output) + ext
 		}
 	}
 
 	return cfg.Output, arc, err
 }
 
+// archiveExt contains the recognized archive file extensions, with compound
+// extensions listed before their suffixes.
+var archiveExt = []string{
+	".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst",
+	".tgz", ".tbz", ".tbz2", ".txz", ".tzst", ".tar", ".zip",
+	".gz", ".bz2", ".xz", ".zst",
+}
+
+// trimArchiveExt removes the archive file extension from the given file name.
+// Compound extensions (e.g., ".tar.gz") are removed entirely. If no archive
+// extension is recognized, any other extension is removed instead.
+func trimArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, e := range archiveExt {
+		if strings.HasSuffix(lower, e) {
+			return name[:len(name)-len(e)]
+		}
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
 // ShellEnv implements io.WriteCloser and provides storage for the exported
 // shell environment script.
 // It also provides methods for formatting and writing the stored contents.
+// The script is formatted using the syntax of its Dialect.
+// Each key is sanitized as a shell identifier, such that distinct keys may be
+// given the same identifier (e.g., "my-repo" and "my_repo"). If Unique is true,
+// each such key is disambiguated by appending a numeric suffix to its
+// identifier; otherwise, it overwrites the value of the earlier key. Either
+// way, each collision is recorded (see Collisions).
+// If Incremental is true, each section may be written as soon as it is
+// complete (see Flush), and Commit writes only the remaining sections.
 type ShellEnv struct {
-	Name   string
-	Writer io.Writer // must never be nil
-	Closer io.Closer // possibly nil (e.g., w = io.Discard)
+	Name        string
+	Dialect     Dialect
+	Writer      io.Writer // must never be nil
+	Closer      io.Closer // possibly nil (e.g., w = io.Discard)
+	Unique      bool      // disambiguate keys with identical identifiers
+	Incremental bool      // write sections with Flush before Commit
 
+	mu      sync.Mutex // guards section, ident, origin, collide, and written
 	section []struct {
 		name string
 		env  *shellEnvSection
 	}
+	ident   map[string]string // identifier of each key appended
+	origin  map[string]string // first key appended with each identifier
+	collide []string          // description of each collision
+	written bool              // any section written by Flush
 }
 
-func NewShellEnv(name string, writer io.Writer, closer io.Closer) *ShellEnv {
+func NewShellEnv(name string, dialect Dialect, writer io.Writer, closer io.Closer) *ShellEnv {
 	return &ShellEnv{
-		Name:   name,
-		Writer: writer,
-		Closer: closer,
+		Name:    name,
+		Dialect: dialect,
+		Writer:  writer,
+		Closer:  closer,
 		section: []struct {
 			name string
 			env  *shellEnvSection
@@ -409,43 +1816,84 @@ func (s *ShellEnv) Close() error {
 // Note that the newline character sequence depends on compile-time target OS,
 // which is "\r\n" for Windows, "\n" for everyone else.
 func (s *ShellEnv) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	var sb strings.Builder
 	for n, sect := range s.section {
 		if n > 0 {
 			sb.WriteString(log.Eol)
 		}
-		sb.WriteString("# " + log.Eol)
-		sb.WriteString("# " + sect.name + log.Eol)
-		sb.WriteString("# " + log.Eol)
+		sb.WriteString(s.Dialect.banner(sect.name))
 		sb.WriteString(sect.env.String())
 	}
 	return sb.String()
 }
 
 func (s *ShellEnv) Commit() (n int, err error) {
+	if s.Incremental {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, sect := range s.section {
+			if !sect.env.flushed {
+				m, err := s.flush(sect.name, sect.env)
+				if n += m; nil != err {
+					return n, err
+				}
+			}
+		}
+		return n, nil
+	}
 	// use the Writer member instead of the receiver ShellEnv so that we may take
 	// advantage if the member implements the optimized WriteString method
 	// (because ShellEnv does not/cannot implement WriteString).
 	return io.WriteString(s.Writer, s.String())
 }
 
+// Flush writes the named section to the receiver's Writer if the receiver is
+// Incremental, so that the script contains each completed section even if the
+// execution fails before Commit. A section is written again by Flush or Commit
+// only if it changes, in which case its later definitions override the earlier.
+// It is safe to call Flush from multiple goroutines.
+func (s *ShellEnv) Flush(section string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if env := s.find(section); s.Incremental && nil != env && !env.flushed {
+		return s.flush(section, env)
+	}
+	return 0, nil
+}
+
+// flush writes the given section to the receiver's Writer, separated from any
+// section written before it.
+// The caller must hold the receiver's mutex.
+func (s *ShellEnv) flush(name string, env *shellEnvSection) (int, error) {
+	var sb strings.Builder
+	if s.written {
+		sb.WriteString(log.Eol)
+	}
+	sb.WriteString(s.Dialect.banner(name))
+	sb.WriteString(env.String())
+	env.flushed, s.written = true, true
+	return io.WriteString(s.Writer, sb.String())
+}
+
 var (
 	reUnderscores = regexp.MustCompile("_+")
 	reNonidents   = regexp.MustCompile("(^[^A-Z_]|[^A-Z0-9_]+)")
-	//reUnescaped  = regexp.MustCompile("(^|[^\\])([\"`$])")
 )
 
+// Append adds the given key-value pair to the named section, creating the
+// section if it does not exist, or updates the value if the key already exists
+// in that section.
+// It is safe to call Append from multiple goroutines.
 func (s *ShellEnv) Append(section, key, val string) {
 
-	var env *shellEnvSection
-	for _, sect := range s.section {
-		if sect.name == section {
-			env = sect.env
-			break
-		}
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	env := s.find(section)
 	if env == nil {
-		env = &shellEnvSection{}
+		env = &shellEnvSection{dialect: s.Dialect}
 		s.section = append(s.section,
 			struct {
 				name string
@@ -456,26 +1904,20 @@ func (s *ShellEnv) Append(section, key, val string) {
 			})
 	}
 
-	// Sanitize key for sh-compatible identifiers
-	key = strings.ToUpper(strings.TrimSpace(key))
-	key = reNonidents.ReplaceAllLiteralString(key, "_")
-	key = reUnderscores.ReplaceAllLiteralString(key, "_")
-	key = strings.Trim(key, "_")
+	key = s.identifier(key)
 
-	// Sanitize val for being enquoted with double-quotes ("") by inserting
-	// an escape "\" before any symbol that delimits string interpolation.
-	// Note that if the symbol has ANY number of preceding escapes "\", then it
-	// will NOT have an escape inserted! This is a convoluted bug I don't
-	// want to deal with at the moment, as the current behavior seems to
-	// have the least surprising results.
-	//val = reUnescaped.ReplaceAllString(val, `${1}\${2}`)
+	// Note that val is stored as-is; it is escaped for the shell dialect when
+	// the script is formatted.
 
 	// check if the given key already exists
 	n := env.Len()
 	for i := 0; i < n; i++ {
 		if env.key[i] == key {
-			env.val[i] = val // found key, update existing value
-			return           // do not add new elements
+			if env.val[i] != val {
+				env.val[i] = val // found key, update existing value
+				env.flushed = false
+			}
+			return // do not add new elements
 		}
 	}
 
@@ -483,12 +1925,175 @@ func (s *ShellEnv) Append(section, key, val string) {
 	env.key = append(env.key, key)
 	env.val = append(env.val, val)
 	env.count++
+	env.flushed = false
+}
+
+// Remove deletes the given key from the named section, if it exists. The
+// section itself is deleted if no keys remain.
+// It is safe to call Remove from multiple goroutines.
+func (s *ShellEnv) Remove(section, key string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	env := s.find(section)
+	if env == nil {
+		return
+	}
+	key = s.lookupIdentifier(key)
+	for i, n := 0, env.Len(); i < n; i++ {
+		if env.key[i] == key {
+			env.key = append(env.key[:i], env.key[i+1:]...)
+			env.val = append(env.val[:i], env.val[i+1:]...)
+			env.count--
+			env.flushed = false
+			break
+		}
+	}
+	if env.Len() == 0 {
+		for i, sect := range s.section {
+			if sect.env == env {
+				s.section = append(s.section[:i], s.section[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Get returns the value of the given key in the named section, and whether or
+// not the key exists.
+// It is safe to call Get from multiple goroutines.
+func (s *ShellEnv) Get(section, key string) (string, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if env := s.find(section); env != nil {
+		key = s.lookupIdentifier(key)
+		for i, n := 0, env.Len(); i < n; i++ {
+			if env.key[i] == key {
+				return env.val[i], true
+			}
+		}
+	}
+	return "", false
+}
+
+// Environ returns the key-value pairs of all sections in the form "KEY=VAL",
+// suitable for the environment of a command (see os/exec.Cmd.Env).
+// It is safe to call Environ from multiple goroutines.
+func (s *ShellEnv) Environ() []string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var env []string
+	for _, sect := range s.section {
+		for i, n := 0, sect.env.Len(); i < n; i++ {
+			env = append(env, sect.env.key[i]+"="+sect.env.val[i])
+		}
+	}
+	return env
+}
+
+// Identifiers returns the shell identifier of each key appended to the
+// receiver, keyed by the original key (e.g., "REPO_My-Repo_URL" maps to
+// "REPO_MY_REPO_URL").
+// It is safe to call Identifiers from multiple goroutines.
+func (s *ShellEnv) Identifiers() map[string]string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ident := make(map[string]string, len(s.ident))
+	for key, id := range s.ident {
+		ident[key] = id
+	}
+	return ident
+}
+
+// Collisions returns a description of each key appended to the receiver whose
+// identifier is the same as that of a different key appended earlier, and
+// how it was resolved (see ShellEnv).
+// It is safe to call Collisions from multiple goroutines.
+func (s *ShellEnv) Collisions() []string {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.collide...)
+}
+
+// identifier returns the shell identifier of the given key, assigning it if
+// the key has not been seen before, and recording any collision with the
+// identifier of a different key.
+// The caller must hold the receiver's mutex.
+func (s *ShellEnv) identifier(key string) string {
+	if id, ok := s.ident[key]; ok {
+		return id
+	}
+	if nil == s.ident {
+		s.ident, s.origin = map[string]string{}, map[string]string{}
+	}
+	id := shellEnvKey(key)
+	if orig, ok := s.origin[id]; ok {
+		if s.Unique {
+			base := id
+			for i := 2; ; i++ {
+				id = fmt.Sprintf("%s_%d", base, i)
+				if _, taken := s.origin[id]; !taken {
+					break
+				}
+			}
+			s.collide = append(s.collide, fmt.Sprintf(
+				"%q and %q are both %s (renamed %s)", orig, key, base, id))
+		} else {
+			s.collide = append(s.collide, fmt.Sprintf(
+				"%q and %q are both %s (overwritten)", orig, key, id))
+		}
+	}
+	s.ident[key] = id
+	if _, ok := s.origin[id]; !ok {
+		s.origin[id] = key
+	}
+	return id
+}
+
+// lookupIdentifier returns the shell identifier of the given key, without
+// assigning it if the key has not been seen before.
+// The caller must hold the receiver's mutex.
+func (s *ShellEnv) lookupIdentifier(key string) string {
+	if id, ok := s.ident[key]; ok {
+		return id
+	}
+	return shellEnvKey(key)
+}
+
+// find returns the named section, or nil if it does not exist.
+// The caller must hold the receiver's mutex.
+func (s *ShellEnv) find(section string) *shellEnvSection {
+	for _, sect := range s.section {
+		if sect.name == section {
+			return sect.env
+		}
+	}
+	return nil
+}
+
+// shellEnvKey returns the given key sanitized as a sh-compatible identifier.
+func shellEnvKey(key string) string {
+	key = strings.ToUpper(strings.TrimSpace(key))
+	key = reNonidents.ReplaceAllLiteralString(key, "_")
+	key = reUnderscores.ReplaceAllLiteralString(key, "_")
+	return strings.Trim(key, "_")
 }
 
 type shellEnvSection struct {
-	count int
-	key   []string
-	val   []string
+	dialect Dialect
+	count   int
+	key     []string
+	val     []string
+	flushed bool // written by Flush, and unchanged since
 }
 
 func (s *shellEnvSection) Len() int {
@@ -503,16 +2108,19 @@ func (s *shellEnvSection) Len() int {
 }
 
 // String creates a newline-delimited string, with each line containing the
-// elements at that line's index from both key and val, separated by a single
-// equals sign, and with val surrounded by double-quotes. For example:
+// elements at that line's index from both key and val, formatted as a variable
+// assignment in the receiver's dialect. For example, with the sh dialect:
 //   key[0]="val[0]"
 //   key[1]="val[1]"
+// Or with the fish dialect:
+//   set -gx key[0] "val[0]"
+//   set -gx key[1] "val[1]"
 // Note that the newline character sequence depends on compile-time target OS,
 // which is "\r\n" for Windows, "\n" for everyone else.
 func (s *shellEnvSection) String() string {
 	var sb strings.Builder
 	for i, n := 0, s.Len(); i < n; i++ {
-		sb.WriteString(s.key[i] + `="` + s.val[i] + `"` + log.Eol)
+		sb.WriteString(s.dialect.assign(s.key[i], s.val[i]) + log.Eol)
 	}
 	return sb.String()
 }
@@ -0,0 +1,345 @@
+package run
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ardnew/svngrab/config"
+	"github.com/ardnew/svngrab/log"
+)
+
+func TestCopyOptionsUnsafeDestination(t *testing.T) {
+	pkg := filepath.Join(t.TempDir(), "pkg")
+	abs := filepath.Join(t.TempDir(), "x")
+	tests := []struct {
+		name     string
+		dst      string
+		allowAbs bool
+		unsafe   bool
+	}{
+		{"parent", "../x", false, true},
+		{"nested parent", "a/../../x", false, true},
+		{"package root", "./", false, false},
+		{"subdirectory", "a/b/", false, false},
+		{"nested within", "a/../b", false, false},
+		{"sibling prefix", "../pkg2/x", false, true},
+		{"sibling prefix root", "../pkg2", false, true},
+		{"absolute", abs, false, true},
+		{"absolute allowed", abs, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.IncludeCopyConfig{Repo: "src"}
+			_, _, _, err := copyOptions(t.TempDir(), pkg, tt.dst, cfg, false, tt.allowAbs, nil, nil)
+			_, unsafe := err.(UnsafeDestinationError)
+			if unsafe != tt.unsafe {
+				t.Errorf("copyOptions(%q, allowAbs=%t) = %v, want unsafe %t",
+					tt.dst, tt.allowAbs, err, tt.unsafe)
+			}
+			if !tt.unsafe && nil != err {
+				t.Errorf("copyOptions(%q) unexpected error: %v", tt.dst, err)
+			}
+		})
+	}
+}
+
+// gitRepo returns the path of a new git repository with a single commit
+// adding the given files, keyed by path, skipping the test if git is not
+// installed.
+func gitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); nil != err {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	for path, content := range files {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(path)), content)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com",
+			"commit", "-q", "--allow-empty", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); nil != err {
+			t.Fatalf("git %s: %v: %s", args[0], err, out)
+		}
+	}
+	return dir
+}
+
+func TestExecuteWritesLastUnderConfiguredKey(t *testing.T) {
+	remote := gitRepo(t, nil)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "svngrab.yml")
+	data := "export:\n" +
+		"  repo-$VER:\n" +
+		"    type: git\n" +
+		"    repo: " + remote + "\n" +
+		"    path: \"\"\n" +
+		"    local: " + filepath.Join(dir, "wc-$VER") + "\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); nil != err {
+		t.Fatal(err)
+	}
+
+	_, err := Execute(Options{ConfigPath: path, Variables: map[string]string{"VER": "1"}})
+	if nil != err {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	cfg, err := config.Parse(path)
+	if nil != err {
+		t.Fatalf("Parse: %v", err)
+	}
+	if expo, ok := cfg.Export["repo-$VER"]; !ok || expo.Last == "" {
+		t.Errorf("export %q: last not written: %+v", "repo-$VER", cfg.Export)
+	}
+	if _, ok := cfg.Export["repo-1"]; ok || len(cfg.Export) != 1 {
+		t.Errorf("expanded export key written: %+v", cfg.Export)
+	}
+}
+
+func TestShellEnvCollisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		unique   bool
+		ident    map[string]string
+		collide  []string
+		contains []string
+		omits    []string
+	}{
+		{
+			name:   "overwrite",
+			unique: false,
+			ident: map[string]string{
+				"REPO_my-repo_URL": "REPO_MY_REPO_URL",
+				"REPO_my_repo_URL": "REPO_MY_REPO_URL",
+			},
+			collide: []string{`"REPO_my-repo_URL" and "REPO_my_repo_URL" are both REPO_MY_REPO_URL (overwritten)`},
+			// the later assignment overwrites the earlier once sourced.
+			contains: []string{"REPO_MY_REPO_URL=first" + log.Eol + log.Eol + "REPO_MY_REPO_URL=second"},
+			omits:    []string{"REPO_MY_REPO_URL_2"},
+		},
+		{
+			name:   "unique",
+			unique: true,
+			ident: map[string]string{
+				"REPO_my-repo_URL": "REPO_MY_REPO_URL",
+				"REPO_my_repo_URL": "REPO_MY_REPO_URL_2",
+			},
+			collide:  []string{`"REPO_my-repo_URL" and "REPO_my_repo_URL" are both REPO_MY_REPO_URL (renamed REPO_MY_REPO_URL_2)`},
+			contains: []string{"REPO_MY_REPO_URL=first", "REPO_MY_REPO_URL_2=second"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := NewShellEnv("test", DotenvDialect, io.Discard, nil)
+			env.Unique = tt.unique
+			env.Append("my-repo", "REPO_my-repo_URL", "first")
+			env.Append("my_repo", "REPO_my_repo_URL", "second")
+			if got := env.Identifiers(); !reflect.DeepEqual(got, tt.ident) {
+				t.Errorf("Identifiers() = %v, want %v", got, tt.ident)
+			}
+			if got := env.Collisions(); !reflect.DeepEqual(got, tt.collide) {
+				t.Errorf("Collisions() = %q, want %q", got, tt.collide)
+			}
+			script := env.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(script, s) {
+					t.Errorf("script does not contain %q:\n%s", s, script)
+				}
+			}
+			for _, s := range tt.omits {
+				if strings.Contains(script, s) {
+					t.Errorf("script contains %q:\n%s", s, script)
+				}
+			}
+		})
+	}
+}
+
+// executeConfig writes the given configuration content to a new file and
+// calls Execute with it, without writing it back.
+func executeConfig(t *testing.T, content string) error {
+	t.Helper()
+	return executeConfigWith(t, content, Options{NoWrite: true})
+}
+
+// executeConfigWith writes the given configuration content to a new file and
+// calls Execute with it using the given options.
+func executeConfigWith(t *testing.T, content string, o Options) error {
+	t.Helper()
+	o.ConfigPath = filepath.Join(t.TempDir(), "svngrab.yml")
+	if err := ioutil.WriteFile(o.ConfigPath, []byte(content), 0644); nil != err {
+		t.Fatal(err)
+	}
+	_, err := Execute(o)
+	return err
+}
+
+// writeFile writes the given content to the given path, creating its parent
+// directories.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); nil != err {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); nil != err {
+		t.Fatal(err)
+	}
+}
+
+func TestCopySingleFileRename(t *testing.T) {
+	tests := []struct {
+		name   string
+		exists bool   // package directory exists before the copy
+		dst    string // copy destination, relative to the package
+	}{
+		{"package exists", true, "tool-v2"},
+		{"package missing", false, "tool-v2"},
+		{"subdirectory missing", false, "bin/tool-v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := t.TempDir()
+			writeFile(t, filepath.Join(src, "bin", "tool"), "tool content")
+			pkg := filepath.Join(t.TempDir(), "package")
+			if tt.exists {
+				if err := os.Mkdir(pkg, 0755); nil != err {
+					t.Fatal(err)
+				}
+			}
+			err := executeConfig(t, "package:\n"+
+				"  "+pkg+":\n"+
+				"    include:\n"+
+				"      - "+src+":\n"+
+				"          - copy: {repo: bin/tool, package: "+tt.dst+"}\n")
+			if nil != err {
+				t.Fatalf("Execute: %v", err)
+			}
+			dst := filepath.Join(pkg, filepath.FromSlash(tt.dst))
+			info, err := os.Stat(dst)
+			if nil != err {
+				t.Fatalf("%s: %v", dst, err)
+			}
+			if !info.Mode().IsRegular() {
+				t.Fatalf("%s: mode %s, want regular file", dst, info.Mode())
+			}
+			if data, _ := ioutil.ReadFile(dst); string(data) != "tool content" {
+				t.Errorf("%s: content %q, want %q", dst, data, "tool content")
+			}
+			if _, err := os.Stat(filepath.Join(dst, "tool")); nil == err {
+				t.Errorf("%s: copied into directory, want renamed file", dst)
+			}
+		})
+	}
+}
+
+func TestExecuteQuietPrintsArchivePath(t *testing.T) {
+	remote := gitRepo(t, map[string]string{"src/file.txt": "content"})
+	dir := t.TempDir()
+	arc := filepath.Join(dir, "pkg.zip")
+
+	// capture everything written to standard output; the quiet log is written
+	// to standard error, as by main.
+	stdout, err := ioutil.TempFile(dir, "stdout")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	orig := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = orig }()
+
+	var stderr bytes.Buffer
+	err = executeConfigWith(t, "export:\n"+
+		"  repo:\n"+
+		"    type: git\n"+
+		"    repo: "+remote+"\n"+
+		"    path: \"\"\n"+
+		"    local: "+filepath.Join(dir, "wc")+"\n"+
+		"package:\n"+
+		"  "+filepath.Join(dir, "pkg")+":\n"+
+		"    include:\n"+
+		"      - repo:\n"+
+		"          - copy: {repo: src, package: .}\n"+
+		"    compress:\n"+
+		"      output: "+arc+"\n"+
+		"      method: zip\n", Options{Log: log.NewQuiet(&stderr)})
+	os.Stdout = orig
+	if nil != err {
+		t.Fatalf("Execute: %v", err)
+	}
+	out, err := ioutil.ReadFile(stdout.Name())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(out) > 0 {
+		t.Errorf("stdout = %q, want empty", out)
+	}
+	if want := arc + log.Eol; stderr.String() != want {
+		t.Errorf("stderr = %q, want %q", stderr.String(), want)
+	}
+}
+
+func TestCopyOnlySkipsDirectories(t *testing.T) {
+	src := t.TempDir()
+	dirs := []string{"a", "a/b", "a/b/c", "a/b/c/d", "e"}
+	for _, dir := range dirs {
+		writeFile(t, filepath.Join(src, filepath.FromSlash(dir), "skip.txt"), "skip")
+	}
+	writeFile(t, filepath.Join(src, "a", "b", "c", "d", "keep.go"), "keep")
+
+	cfg := config.IncludeCopyConfig{Repo: ".", Only: []string{"*.go"}, IgnoreStyle: "glob"}
+	_, _, opt, err := copyOptions(src, t.TempDir(), "out", cfg, false, false, nil, nil)
+	if nil != err {
+		t.Fatal(err)
+	}
+	// only directories without any file matching the pattern are skipped.
+	want := map[string]bool{"a": false, "a/b": false, "a/b/c": false, "a/b/c/d": false, "e": true}
+	for _, dir := range append([]string{"."}, dirs...) {
+		skip, err := opt.Skip(filepath.Join(src, filepath.FromSlash(dir)))
+		if nil != err {
+			t.Fatalf("%s: %v", dir, err)
+		}
+		if skip != want[dir] {
+			t.Errorf("%s: skip = %t, want %t", dir, skip, want[dir])
+		}
+	}
+}
+
+func TestWantedTreeWalksOnce(t *testing.T) {
+	src := t.TempDir()
+	dirs := []string{".", "a", "a/b", "a/b/c", "a/b/c/d"}
+	for _, dir := range dirs {
+		writeFile(t, filepath.Join(src, filepath.FromSlash(dir), "file.txt"), "content")
+	}
+	calls := map[string]int{}
+	tree := newWantedTree(func(string) bool { return false },
+		func(s string, info os.FileInfo) bool {
+			calls[s]++
+			return false
+		})
+	for _, dir := range dirs {
+		if found, err := tree.contains(filepath.Join(src, filepath.FromSlash(dir))); nil != err || found {
+			t.Errorf("%s: contains = %t, %v, want false", dir, found, err)
+		}
+	}
+	if len(calls) != len(dirs) {
+		t.Errorf("accept called for %d files, want %d", len(calls), len(dirs))
+	}
+	for path, n := range calls {
+		if n != 1 {
+			t.Errorf("%s: accept called %d times, want 1", path, n)
+		}
+	}
+}
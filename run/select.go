@@ -0,0 +1,151 @@
+package run
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ardnew/svngrab/config"
+	"github.com/ardnew/svngrab/log"
+)
+
+// selectConfig returns the exports and packages of the given configuration
+// that are selected by the given lists of repository and package names, keyed
+// as in the configuration. Names may be given either as written in the
+// configuration or with variable substitution performed.
+//
+// If only is non-empty, just the named repositories and packages are selected,
+// along with every repository included by a selected package. Then, each of
+// the repositories and packages named by except are deselected.
+//
+// Returns InvalidSelectorError if any name is neither a repository nor a
+// package, or if a selected package includes a deselected repository.
+func selectConfig(l *log.Log, cfg *config.Config, ex *expander, only, except []string) (config.ExportMap, config.PackageMap, error) {
+
+	if len(only) == 0 && len(except) == 0 {
+		return cfg.Export, cfg.Package, nil
+	}
+
+	l.Infof("conf", "selecting repositories and packages ...")
+	exports, packages, err := selectNames(cfg, ex, only, except)
+	l.Eolf("conf", err, " (%d repositories, %d packages)", len(exports), len(packages))
+	if nil == err {
+		err = ex.check(l)
+	}
+	if nil != err {
+		return nil, nil, err
+	}
+	return exports, packages, nil
+}
+
+// selectNames returns the exports and packages selected by the given lists of
+// names. See selectConfig for details.
+func selectNames(cfg *config.Config, ex *expander, only, except []string) (config.ExportMap, config.PackageMap, error) {
+
+	// index each repository and package by both of its names.
+	exports := map[string]string{}
+	packages := map[string]string{}
+	for key := range cfg.Export {
+		exports[key], exports[ex.expand(key)] = key, key
+	}
+	for key := range cfg.Package {
+		packages[key], packages[ex.expand(key)] = key, key
+	}
+
+	var unknown []string
+	for _, name := range append(append([]string{}, only...), except...) {
+		_, isExport := exports[name]
+		_, isPackage := packages[name]
+		if !isExport && !isPackage {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, nil, InvalidSelectorError("unknown repository or package: " +
+			strings.Join(unknown, ", "))
+	}
+
+	selExport := config.ExportMap{}
+	selPackage := config.PackageMap{}
+	if len(only) == 0 {
+		for key, expo := range cfg.Export {
+			selExport[key] = expo
+		}
+		for key, pkg := range cfg.Package {
+			selPackage[key] = pkg
+		}
+	} else {
+		for _, name := range only {
+			if key, ok := exports[name]; ok {
+				selExport[key] = cfg.Export[key]
+			}
+			if key, ok := packages[name]; ok {
+				selPackage[key] = cfg.Package[key]
+				for _, repo := range includedRepos(ex, exports, cfg.Package[key]) {
+					selExport[repo] = cfg.Export[repo]
+				}
+			}
+		}
+	}
+	for _, name := range except {
+		delete(selExport, exports[name])
+		delete(selPackage, packages[name])
+	}
+
+	// a package cannot be built without each of its repositories, whose include
+	// paths would otherwise be interpreted as local file paths.
+	var missing []string
+	for key, pkg := range selPackage {
+		for _, repo := range includedRepos(ex, exports, pkg) {
+			if _, ok := selExport[repo]; !ok {
+				missing = append(missing, ex.expand(key)+" requires "+ex.expand(repo))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, nil, InvalidSelectorError("excluded repository: " +
+			strings.Join(missing, ", "))
+	}
+
+	return selExport, selPackage, nil
+}
+
+// includedRepos returns the configuration keys of each repository in the given
+// index of repository names that is included by the given package.
+func includedRepos(ex *expander, exports map[string]string, pkg config.PackageConfig) []string {
+	var repos []string
+	for _, inc := range pkg.Include {
+		for path := range inc {
+			if key, ok := exports[ex.expand(path)]; ok {
+				repos = append(repos, key)
+			}
+		}
+	}
+	return repos
+}
+
+// changedPackages returns the given packages that include at least one
+// repository whose revision changed in revs, keyed by expanded name, or that
+// include no exported repository at all (e.g., only local paths), logging each
+// package skipped.
+func changedPackages(l *log.Log, ex *expander, packages config.PackageMap, revs map[string]revRange) config.PackageMap {
+	changed := config.PackageMap{}
+	for key, pkg := range packages {
+		build, depends := false, false
+		for _, inc := range pkg.Include {
+			for path := range inc {
+				if rev, ok := revs[ex.expand(path)]; ok {
+					depends = true
+					build = build || rev.from != rev.to
+				}
+			}
+		}
+		if build || !depends {
+			changed[key] = pkg
+			continue
+		}
+		l.Infof("pack", "%s: skipped, repositories unchanged (see -force)", ex.expand(key))
+		l.Break()
+	}
+	return changed
+}
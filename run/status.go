@@ -0,0 +1,84 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Status reports, for each repository in the configuration file of the given
+// options, the revision recorded by the last export and the revision of the
+// remote repository, without exporting anything. The report is written to w
+// as an aligned table.
+// Returns WorkingCopiesUpToDate(true) if every repository is up-to-date.
+func Status(o Options, w io.Writer) error {
+
+	o = o.normalize()
+	l := o.Log
+
+	cfg, err := parseConfig(l, o.ConfigPath)
+	if nil != err {
+		return err
+	}
+
+	ex := newExpander(o.StrictVars, o.Variables)
+
+	exports, _, err := selectConfig(l, cfg, ex, o.Only, o.Except)
+	if nil != err {
+		return err
+	}
+
+	cacheDir := ex.expand(cfg.CacheDir)
+	svn := svnCommand(ex, cfg, o)
+
+	names := make([]string, 0, len(exports))
+	for name := range exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tLOCAL\tREMOTE\tUP-TO-DATE")
+
+	current := true
+	for _, key := range names {
+		name, expo := expandExport(ex, cacheDir, key, exports[key])
+		if err := ex.check(l); nil != err {
+			return err
+		}
+		rep, err := openRepo(l, name, expo, svn, o.Retries, o.Timeout)
+		if nil != err {
+			return err
+		}
+		l.Infof("stat", "querying remote revision: %s ...", name)
+		remote, err := rep.RemoteRevision()
+		l.Eolf("stat", err, " (%s)", remote)
+		if nil != err {
+			return err
+		}
+		local := expo.Last
+		if local == "" {
+			local = "-"
+		}
+		upToDate := expo.Last == remote
+		current = current && upToDate
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, local, remote, yesNo(upToDate))
+	}
+	if err := tw.Flush(); nil != err {
+		return err
+	}
+
+	if current {
+		return WorkingCopiesUpToDate(true)
+	}
+	return nil
+}
+
+// yesNo returns "yes" if b is true, otherwise "no".
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,225 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/ardnew/svngrab/repo"
+)
+
+// summary records the results of an execution as they occur.
+// It is safe to record results from multiple goroutines.
+type summary struct {
+	repos    map[string]*RepoSummary
+	packages map[string]*PackageSummary
+
+	mu sync.Mutex
+}
+
+// Result describes the results of an execution (see Execute) in a
+// machine-readable form, suitable for encoding as JSON.
+type Result struct {
+	Repos    map[string]RepoSummary    `json:"repos"`
+	Packages map[string]PackageSummary `json:"packages"`
+}
+
+// RepoSummary describes the results of exporting a single repository.
+// Paths is recorded only if requested (see Options.ChangedPaths).
+type RepoSummary struct {
+	PrevRev string             `json:"prevrev"`
+	CurrRev string             `json:"currrev"`
+	Changed bool               `json:"changed"`
+	Paths   []repo.ChangedPath `json:"paths,omitempty"`
+}
+
+// PackageSummary describes the results of building a single package.
+type PackageSummary struct {
+	Copied  []string      `json:"copied"`
+	Skipped []SkippedFile `json:"skipped,omitempty"` // exceeding maxFileSize
+	Archive string        `json:"archive,omitempty"`
+	Size    int64         `json:"size,omitempty"`
+}
+
+// SkippedFile describes a file that was not copied into a package.
+type SkippedFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// newSummary returns a pointer to a new, empty summary.
+func newSummary() *summary {
+	return &summary{
+		repos:    map[string]*RepoSummary{},
+		packages: map[string]*PackageSummary{},
+	}
+}
+
+// addRepo records the previous and current revisions of the named repository.
+func (s *summary) addRepo(name, prev, curr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repos[name] = &RepoSummary{PrevRev: prev, CurrRev: curr, Changed: prev != curr}
+}
+
+// setPaths records the paths changed in the named repository.
+func (s *summary) setPaths(name string, paths []repo.ChangedPath) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rs, ok := s.repos[name]; ok {
+		rs.Paths = paths
+	}
+}
+
+// pkg returns the summary of the named package, creating it if necessary.
+// The receiver's mutex must be held by the caller.
+func (s *summary) pkg(name string) *PackageSummary {
+	p, ok := s.packages[name]
+	if !ok {
+		p = &PackageSummary{Copied: []string{}}
+		s.packages[name] = p
+	}
+	return p
+}
+
+// addCopy records a path copied into the named package.
+func (s *summary) addCopy(name, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pkg(name)
+	p.Copied = append(p.Copied, path)
+}
+
+// addSkipped records a file of the given size that was not copied into the
+// named package.
+func (s *summary) addSkipped(name, path string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pkg(name)
+	p.Skipped = append(p.Skipped, SkippedFile{Path: path, Size: size})
+}
+
+// setArchive records the path and size of the named package's archive.
+func (s *summary) setArchive(name, path string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pkg(name)
+	p.Archive, p.Size = path, size
+}
+
+// result returns a copy of the results recorded by the receiver.
+func (s *summary) result() Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := Result{
+		Repos:    make(map[string]RepoSummary, len(s.repos)),
+		Packages: make(map[string]PackageSummary, len(s.packages)),
+	}
+	for name, rs := range s.repos {
+		rc := *rs
+		rc.Paths = append([]repo.ChangedPath(nil), rs.Paths...)
+		r.Repos[name] = rc
+	}
+	for name, ps := range s.packages {
+		p := *ps
+		p.Copied = append([]string{}, ps.Copied...)
+		p.Skipped = append([]SkippedFile(nil), ps.Skipped...)
+		r.Packages[name] = p
+	}
+	return r
+}
+
+// WriteJSON writes the receiver to the given io.Writer as an indented JSON
+// document.
+func (r Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteChanges writes the paths changed in each repository (see RepoSummary)
+// to the given io.Writer as tab-separated values, with a header line, ordered
+// by repository name: "repo", "action", and "path".
+func (r Result) WriteChanges(w io.Writer) error {
+	names := make([]string, 0, len(r.Repos))
+	for name := range r.Repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if _, err := fmt.Fprintln(w, "repo\taction\tpath"); nil != err {
+		return err
+	}
+	for _, name := range names {
+		for _, p := range r.Repos[name].Paths {
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", name, p.Action, p.Path); nil != err {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteTable writes the receiver to the given io.Writer as aligned columns,
+// ordered by name: each repository with its previous and current revisions and
+// whether it changed, followed by each package with the path and size of its
+// archive (if any). The columns of each section are aligned independently.
+func (r Result) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(r.Repos) > 0 {
+		fmt.Fprintln(tw, "repository\trevision\tstatus")
+		names := make([]string, 0, len(r.Repos))
+		for name := range r.Repos {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rs := r.Repos[name]
+			prev, status := rs.PrevRev, "unchanged"
+			if prev == "" {
+				prev = "(none)"
+			}
+			if rs.Changed {
+				status = "changed"
+			}
+			fmt.Fprintf(tw, "%s\t%s -> %s\t%s\n", name, prev, rs.CurrRev, status)
+		}
+		if err := tw.Flush(); nil != err {
+			return err
+		}
+	}
+	if len(r.Packages) > 0 {
+		fmt.Fprintln(tw, "package\tarchive\tsize")
+		names := make([]string, 0, len(r.Packages))
+		for name := range r.Packages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ps := r.Packages[name]
+			archive, size := ps.Archive, formatSize(ps.Size)
+			if archive == "" {
+				archive, size = "(none)", ""
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, archive, size)
+		}
+	}
+	return tw.Flush()
+}
+
+// formatSize returns the given number of bytes as a human-readable string with
+// a binary unit suffix (e.g., "1.5 MiB").
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,354 @@
+package run
+
+import (
+	"os"
+	"os/user"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ardnew/svngrab/log"
+)
+
+// Type definitions for errors raised by variable substitution.
+type (
+	// UndefinedVariableError is raised when strict variable substitution is
+	// enabled and a variable reference has no definition.
+	UndefinedVariableError string
+	// VariableCycleError is raised when the value of a variable references
+	// itself, directly or through other variables.
+	VariableCycleError string
+)
+
+// VariableError is implemented by every error raised by variable
+// substitution, so that they may be handled together.
+type VariableError interface {
+	error
+	// Variable returns the reference of the offending variable (e.g., "$VAR").
+	Variable() string
+}
+
+// Error returns the string representation of UndefinedVariableError
+func (e UndefinedVariableError) Error() string {
+	return "undefined variable: " + string(e)
+}
+
+// Type returns "VariableError", the type name reported for every variable error
+func (e UndefinedVariableError) Type() string {
+	return "VariableError"
+}
+
+// Variable returns the undefined variable reference.
+func (e UndefinedVariableError) Variable() string {
+	return string(e)
+}
+
+// Error returns the string representation of VariableCycleError
+func (e VariableCycleError) Error() string {
+	return "variable reference cycle: " + string(e)
+}
+
+// Type returns "VariableError", the type name reported for every variable error
+func (e VariableCycleError) Type() string {
+	return "VariableError"
+}
+
+// Variable returns the first variable identifier of the cycle.
+func (e VariableCycleError) Variable() string {
+	return strings.SplitN(string(e), " -> ", 2)[0]
+}
+
+// maxVarDepth is the maximum depth of variables referenced by the values of
+// other variables, beyond which substitution fails as if a cycle were found.
+const maxVarDepth = 32
+
+// Variable contains the builtin variables available for substitution in the
+// configuration file. User-defined variables (see Options.Variables) override
+// these definitions, but are never added to them.
+var Variable = map[string]string{
+	"$DATE":     time.Now().Local().Format("20060102"),
+	"$DATETIME": time.Now().Local().Format("20060102-150405"),
+}
+
+// lazyVariable contains the builtin variables whose values are resolved only
+// when referenced, because resolving them may fail. A variable whose value
+// cannot be resolved is undefined (and may still be defined by the
+// environment). Unlike those in Variable, these are only matched by complete
+// identifiers, so that, for example, $HOSTNAME is not mistaken for $HOST.
+var lazyVariable = map[string]func() (string, error){
+	"$CWD":  os.Getwd,
+	"$HOST": os.Hostname,
+	"$USER": func() (string, error) {
+		u, err := user.Current()
+		if nil != err {
+			return "", err
+		}
+		return u.Username, nil
+	},
+}
+
+// varRef matches a variable reference of the form $NAME.
+var varRef = regexp.MustCompile(`^\$[A-Za-z_][A-Za-z0-9_]*`)
+
+// expander performs variable substitution on strings from the configuration
+// file.
+// The first error encountered is retained in err, so that many strings may be
+// expanded before checking for errors.
+type expander struct {
+	strict bool              // raise an error for undefined variables
+	empty  bool              // replace undefined variables with the empty string
+	scope  map[string]string // variables defined only for this expander
+	stack  []string          // identifiers of the variables being resolved
+	err    error
+}
+
+// newExpander returns a new expander, which defines the given user-defined
+// variables, keyed by identifier with its leading "$" (overriding those in
+// Variable), and raises UndefinedVariableError for undefined variables if
+// strict is true.
+func newExpander(strict bool, vars map[string]string) *expander {
+	return &expander{strict: strict, scope: vars}
+}
+
+// within returns a new expander with the same options and variables as the
+// receiver, which also defines the given variables (overriding those of the
+// receiver and Variable).
+// Errors retained by the new expander are not retained by the receiver.
+func (e *expander) within(scope map[string]string) *expander {
+	merged := make(map[string]string, len(e.scope)+len(scope))
+	for k, v := range e.scope {
+		merged[k] = v
+	}
+	for k, v := range scope {
+		merged[k] = v
+	}
+	return &expander{strict: e.strict, empty: e.empty, scope: merged}
+}
+
+// identifiers returns the identifiers defined in Variable and the receiver's
+// scope, longest first.
+func (e *expander) identifiers() []string {
+	ident := make([]string, 0, len(Variable)+len(e.scope))
+	for k := range Variable {
+		ident = append(ident, k)
+	}
+	for k := range e.scope {
+		if _, ok := Variable[k]; !ok {
+			ident = append(ident, k)
+		}
+	}
+	sort.Slice(ident, func(i, j int) bool {
+		if len(ident[i]) != len(ident[j]) {
+			return len(ident[i]) > len(ident[j])
+		}
+		return ident[i] < ident[j]
+	})
+	return ident
+}
+
+// expand performs substitution of every variable reference found in s with
+// its value. The values of variables defined in Variable or the receiver's
+// scope are themselves expanded, so that variables may be defined in terms of
+// other variables (e.g., OUT=dist/$TAG); a VariableCycleError is retained if a
+// value references itself, directly or indirectly.
+// Variables defined in Variable are matched first, with longer identifiers
+// matched before shorter ones, so that a reference to $DATETIME is never
+// mistaken for $DATE followed by "TIME".
+// Any other reference of the form $NAME is resolved from the environment. If
+// the environment does not define NAME, the reference is left as-is, or an
+// UndefinedVariableError is retained if the receiver is strict.
+//
+// The following shell-style forms are also recognized:
+//
+//	${NAME}         value of NAME
+//	${NAME:-word}   value of NAME if non-empty, otherwise word
+//	${NAME:+word}   word if NAME is non-empty, otherwise the empty string
+//
+// A dollar sign preceded by a backslash (\$) is replaced with a literal dollar
+// sign and never starts a variable reference.
+func (e *expander) expand(s string) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	ident := e.identifiers()
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], `\$`) {
+			sb.WriteByte('$')
+			i += 2
+			continue
+		}
+		if s[i] != '$' {
+			sb.WriteByte(s[i])
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], "${") {
+			if n := braceLen(s[i:]); n > 0 {
+				sb.WriteString(e.expandBraced(s[i : i+n]))
+				i += n
+				continue
+			}
+		}
+		if k := matchIdent(s[i:], ident); k != "" {
+			sb.WriteString(e.value(k))
+			i += len(k)
+			continue
+		}
+		ref := varRef.FindString(s[i:])
+		if ref == "" {
+			sb.WriteByte(s[i])
+			i++
+			continue
+		}
+		if value, ok := e.lookup(ref[1:]); ok {
+			sb.WriteString(value)
+		} else {
+			sb.WriteString(e.undefined(ref))
+		}
+		i += len(ref)
+	}
+	return sb.String()
+}
+
+// expandBraced returns the value of the given braced variable reference, which
+// must begin with "${" and end with its matching "}".
+func (e *expander) expandBraced(ref string) string {
+	body := ref[2 : len(ref)-1]
+	name, op, word := body, "", ""
+	if i := strings.Index(body, ":"); i >= 0 && i+1 < len(body) &&
+		(body[i+1] == '-' || body[i+1] == '+') {
+		name, op, word = body[:i], body[i:i+2], body[i+2:]
+	}
+	value, ok := e.lookup(name)
+	switch op {
+	case ":-":
+		if value == "" {
+			return e.expand(word)
+		}
+	case ":+":
+		if value == "" {
+			return ""
+		}
+		return e.expand(word)
+	default:
+		if !ok {
+			return e.undefined(ref)
+		}
+	}
+	return value
+}
+
+// undefined retains an UndefinedVariableError for the given reference if the
+// receiver is strict and no error has been retained yet.
+// Returns the string that replaces the reference: the empty string if the
+// receiver replaces undefined variables with the empty string, otherwise the
+// reference itself.
+func (e *expander) undefined(ref string) string {
+	if e.strict && nil == e.err {
+		e.err = UndefinedVariableError(ref)
+	}
+	if e.empty {
+		return ""
+	}
+	return ref
+}
+
+// value returns the expanded value of the given identifier (with its leading
+// "$") from the receiver's scope, or from Variable if not defined in scope.
+func (e *expander) value(ident string) string {
+	if value, ok := e.scope[ident]; ok {
+		return e.resolve(ident, value)
+	}
+	return e.resolve(ident, Variable[ident])
+}
+
+// lookup returns the value of the named variable (without its leading "$")
+// from the receiver's scope or Variable, expanded, or from lazyVariable or the
+// environment as-is if not defined in either.
+func (e *expander) lookup(name string) (string, bool) {
+	if value, ok := e.scope["$"+name]; ok {
+		return e.resolve("$"+name, value), true
+	}
+	if value, ok := Variable["$"+name]; ok {
+		return e.resolve("$"+name, value), true
+	}
+	if resolve, ok := lazyVariable["$"+name]; ok {
+		if value, err := resolve(); nil == err {
+			return value, true
+		}
+	}
+	return os.LookupEnv(name)
+}
+
+// resolve returns the given value of the given identifier with all of its
+// variable references expanded.
+// If the identifier is already being resolved (i.e., its value references
+// itself), or if references are nested too deeply, the value is returned
+// unexpanded and a VariableCycleError listing the cycle is retained.
+func (e *expander) resolve(ident, value string) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+	for i, s := range e.stack {
+		if s == ident {
+			e.cycle(append(e.stack[i:len(e.stack):len(e.stack)], ident))
+			return value
+		}
+	}
+	if len(e.stack) >= maxVarDepth {
+		e.cycle(append(e.stack[:len(e.stack):len(e.stack)], ident))
+		return value
+	}
+	e.stack = append(e.stack, ident)
+	value = e.expand(value)
+	e.stack = e.stack[:len(e.stack)-1]
+	return value
+}
+
+// cycle retains a VariableCycleError for the given chain of identifiers if no
+// error has been retained yet.
+func (e *expander) cycle(chain []string) {
+	if nil == e.err {
+		e.err = VariableCycleError(strings.Join(chain, " -> "))
+	}
+}
+
+// braceLen returns the length of the braced variable reference at the start
+// of s (beginning with "${"), including nested references and the closing
+// brace, or 0 if the braces are unbalanced.
+func braceLen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// matchIdent returns the first of the given identifiers that is a prefix of s,
+// or the empty string if none match.
+func matchIdent(s string, ident []string) string {
+	for _, k := range ident {
+		if strings.HasPrefix(s, k) {
+			return k
+		}
+	}
+	return ""
+}
+
+// check logs and returns the first error retained by the receiver, if any.
+func (e *expander) check(l *log.Log) error {
+	if nil != e.err {
+		l.Errorf("vars", "%s", e.err)
+		l.Break()
+	}
+	return e.err
+}
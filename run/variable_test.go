@@ -0,0 +1,62 @@
+package run
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ardnew/svngrab/config"
+)
+
+func TestDateVariables(t *testing.T) {
+	dateFormat := regexp.MustCompile(`^\d{8}$`)
+	datetimeFormat := regexp.MustCompile(`^\d{8}-\d{6}$`)
+	date, datetime := Variable["$DATE"], Variable["$DATETIME"]
+	if !dateFormat.MatchString(date) {
+		t.Fatalf("$DATE = %q, want YYYYMMDD", date)
+	}
+	if !datetimeFormat.MatchString(datetime) {
+		t.Fatalf("$DATETIME = %q, want YYYYMMDD-hhmmss", datetime)
+	}
+	if _, err := time.ParseInLocation("20060102-150405", datetime, time.Local); nil != err {
+		t.Errorf("$DATETIME = %q: %v", datetime, err)
+	}
+	if datetime[:8] != date {
+		t.Errorf("$DATETIME = %q, not on $DATE = %q", datetime, date)
+	}
+
+	ex := newExpander(true, nil)
+	expo := config.ExportConfig{Path: "tags/$DATE", Local: "wc-${DATETIME}"}.Expand(ex.expand)
+	pkg := config.PackageConfig{Compress: config.CompressConfig{
+		Output: "dist/pkg-$DATETIME-$DATE.zip"}}.Expand(ex.expand)
+	if err := ex.err; nil != err {
+		t.Fatalf("expand: %v", err)
+	}
+	for _, tt := range []struct{ field, got, want string }{
+		{"export path", expo.Path, "tags/" + date},
+		{"export local", expo.Local, "wc-" + datetime},
+		{"compress output", pkg.Compress.Output, "dist/pkg-" + datetime + "-" + date + ".zip"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+}
+
+func TestUserVariablesNotGlobal(t *testing.T) {
+	vars := map[string]string{"$SVNGRAB_TEST_VAR": "one"}
+	ex := newExpander(true, vars)
+	if got := ex.expand("$SVNGRAB_TEST_VAR"); got != "one" {
+		t.Errorf("expand = %q, want %q", got, "one")
+	}
+	if _, ok := Variable["$SVNGRAB_TEST_VAR"]; ok {
+		t.Errorf("user variable added to Variable")
+	}
+	if got := newExpander(false, nil).expand("$SVNGRAB_TEST_VAR"); got != "$SVNGRAB_TEST_VAR" {
+		t.Errorf("expand without user variables = %q, want reference unexpanded", got)
+	}
+	inner := ex.within(map[string]string{"$REPO_x_CURRREV": "2"})
+	if got := inner.expand("$SVNGRAB_TEST_VAR-$REPO_x_CURRREV"); got != "one-2" {
+		t.Errorf("within: expand = %q, want %q", got, "one-2")
+	}
+}
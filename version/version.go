@@ -0,0 +1,24 @@
+// Package version contains the build metadata of the executable.
+// Each of these variables is stamped at build time via the Go linker (e.g.,
+// -ldflags='-X "github.com/ardnew/svngrab/version.VERSION=1.2.3"').
+package version
+
+import "fmt"
+
+// Build metadata exported verbatim by the Makefile via Go linker.
+var (
+	PROJECT   string
+	IMPORT    string
+	VERSION   string
+	BUILDTIME string
+	PLATFORM  string
+	BRANCH    string
+	REVISION  string
+)
+
+// String returns a single line describing the build, including its version,
+// target platform, git branch and revision, and build time.
+func String() string {
+	return fmt.Sprintf("%s %s %s %s@%s %s",
+		IMPORT, VERSION, PLATFORM, BRANCH, REVISION, BUILDTIME)
+}